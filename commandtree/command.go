@@ -23,6 +23,12 @@ type CommandNode interface {
 	AsCommand() *Command
 }
 
+// Describer is implemented by commands that can explain what they would do
+// when executed, without actually executing. Used to print deployment plans.
+type Describer interface {
+	Describe() []string
+}
+
 type Command struct {
 	mutex         sync.RWMutex
 	ID            string
@@ -34,6 +40,8 @@ type Command struct {
 	anyError      bool
 	Children      []CommandNode
 	RemoteCommand bool
+	Limits        []string // limit groups (see Runner.SetLimit) the command counts against while running
+	IgnoreErrors  bool     // if true, errors in the command (or its children) don't fail the run
 	result        interface{}
 	progress      float64
 }
@@ -2,6 +2,7 @@ package commandtree
 
 import (
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -21,6 +22,66 @@ func TestCommandTree(t *testing.T) {
 	ConsoleUI(root)
 }
 
+func TestRunnerLimits(t *testing.T) {
+	var mutex sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	track := func(group string) func(c *Command) {
+		return func(c *Command) {
+			mutex.Lock()
+			running[group]++
+			if running[group] > maxRunning[group] {
+				maxRunning[group] = running[group]
+			}
+			mutex.Unlock()
+			time.Sleep(time.Millisecond * 30)
+			mutex.Lock()
+			running[group]--
+			mutex.Unlock()
+		}
+	}
+
+	root := NewRootCommand("limits")
+	for i := 0; i != 6; i++ {
+		root.Add("limited", NewFuncCommand(track("limited"))).AsCommand().Limits = []string{"limited"}
+		root.Add("free", NewFuncCommand(track("free")))
+	}
+
+	r := NewRunner(root, 10)
+	r.SetLimit("limited", 2)
+	if !r.Run(nil) {
+		t.Fatal("expected the run to succeed")
+	}
+	if maxRunning["limited"] != 2 {
+		t.Errorf("expected at most 2 limited commands at a time, got %v", maxRunning["limited"])
+	}
+	if maxRunning["free"] < 3 {
+		t.Errorf("expected the free commands to run in parallel, got %v at a time", maxRunning["free"])
+	}
+	for _, child := range root.Children {
+		if child.AsCommand().State != CommandStateCompleted {
+			t.Errorf("expected all commands to complete")
+		}
+	}
+}
+
+func TestRunnerIgnoreErrors(t *testing.T) {
+	root := NewRootCommand("ignore errors")
+	root.Add("fails", NewFuncCommand(func(c *Command) { c.Errf("failed") })).AsCommand().IgnoreErrors = true
+	root.Add("works", NewFuncCommand(func(c *Command) {}))
+
+	r := NewRunner(root, 2)
+	if !r.Run(nil) {
+		t.Errorf("expected errors of commands with IgnoreErrors to not fail the run")
+	}
+
+	root.Add("also fails", NewFuncCommand(func(c *Command) { c.Errf("failed") }))
+	r = NewRunner(root, 2)
+	if r.Run(nil) {
+		t.Errorf("expected the run to fail")
+	}
+}
+
 type PrintMachineCommand struct {
 	Command
 }
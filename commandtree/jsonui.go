@@ -0,0 +1,171 @@
+package commandtree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/oliverkofoed/dogo/neaterror"
+)
+
+var monitorEventNames = map[monitorEventType]string{
+	monitorEventChildAdded:  "child_added",
+	monitorEventStateChange: "state_change",
+	monitorEventLog:         "log",
+	monitorEventResult:      "result",
+	monitorEventPanic:       "panic",
+}
+
+var commandStateNames = map[CommandState]string{
+	CommandStateReady:     "ready",
+	CommandStateRunning:   "running",
+	CommandStatePaused:    "paused",
+	CommandStateCompleted: "completed",
+}
+
+// jsonEvent is a MonitorEvent (or the final summary) as written by JSONUI
+type jsonEvent struct {
+	Event    string         `json:"event"`
+	Time     time.Time      `json:"time"`
+	ID       string         `json:"id,omitempty"`
+	ParentID string         `json:"parent,omitempty"`
+	Caption  string         `json:"caption,omitempty"`
+	State    string         `json:"state,omitempty"`
+	Message  string         `json:"message,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Result   interface{}    `json:"result,omitempty"`
+	Success  *bool          `json:"success,omitempty"`
+	Duration float64        `json:"duration,omitempty"`
+	Errors   []jsonUIErrors `json:"errors,omitempty"`
+}
+
+type jsonUIErrors struct {
+	Path   []string `json:"path"`
+	Errors []string `json:"errors"`
+}
+
+type jsonUICommand struct {
+	state    CommandState
+	logCount int
+	result   bool
+}
+
+type jsonUI struct {
+	encoder  *json.Encoder
+	commands map[*Command]*jsonUICommand
+}
+
+// JSONUI writes the progress of the command tree to w as newline delimited
+// JSON monitor events (child_added, state_change, log, result), followed by
+// a summary event once all commands are done.
+func JSONUI(root CommandNode, w io.Writer) error {
+	j := &jsonUI{
+		encoder:  json.NewEncoder(w),
+		commands: make(map[*Command]*jsonUICommand),
+	}
+	start := time.Now()
+
+	startInteruptable()
+	for {
+		runInterupts(func() {})
+		if j.emit(root.AsCommand(), "") {
+			break
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+	endInteruptable()
+
+	// summary
+	errs := make([]jsonUIErrors, 0)
+	j.getErrors(root.AsCommand(), nil, &errs)
+	success := len(errs) == 0
+	j.write(&jsonEvent{
+		Event:    "summary",
+		Time:     time.Now(),
+		Caption:  root.AsCommand().Caption,
+		Success:  &success,
+		Duration: time.Since(start).Seconds(),
+		Errors:   errs,
+	})
+
+	if !success {
+		return fmt.Errorf("%v errors during run", len(errs))
+	}
+	return nil
+}
+
+// emit writes events for everything that changed in the command since the
+// last call. Returns true if the command and all its children are done.
+func (j *jsonUI) emit(cmd *Command, parentID string) bool {
+	cmd.mutex.RLock()
+	id := cmd.ID
+	if id == "" {
+		id = makeID(cmd)
+	}
+	state := cmd.State
+	logs := cmd.LogArray
+	children := cmd.Children
+	result := cmd.result
+	cmd.mutex.RUnlock()
+
+	known, found := j.commands[cmd]
+	if !found {
+		known = &jsonUICommand{state: CommandStateReady}
+		j.commands[cmd] = known
+		j.write(&jsonEvent{Event: monitorEventNames[monitorEventChildAdded], Time: time.Now(), ID: id, ParentID: parentID, Caption: cmd.Caption})
+	}
+
+	if state != known.state {
+		known.state = state
+		j.write(&jsonEvent{Event: monitorEventNames[monitorEventStateChange], Time: time.Now(), ID: id, State: commandStateNames[state]})
+	}
+
+	for ; known.logCount < len(logs); known.logCount++ {
+		entry := logs[known.logCount]
+		evt := &jsonEvent{Event: monitorEventNames[monitorEventLog], Time: entry.Time, ID: id, Message: entry.Message}
+		if entry.Error != nil {
+			evt.Error = neaterror.String("", entry.Error, false)
+		}
+		j.write(evt)
+	}
+
+	if result != nil && !known.result {
+		known.result = true
+		if _, err := json.Marshal(result); err != nil {
+			result = fmt.Sprintf("%v", result)
+		}
+		j.write(&jsonEvent{Event: monitorEventNames[monitorEventResult], Time: time.Now(), ID: id, Result: result})
+	}
+
+	done := state == CommandStateCompleted
+	for _, child := range children {
+		childDone := j.emit(child.AsCommand(), id)
+		done = done && childDone
+	}
+	return done
+}
+
+func (j *jsonUI) write(evt *jsonEvent) {
+	j.encoder.Encode(evt)
+}
+
+func (j *jsonUI) getErrors(cmd *Command, path []string, arr *[]jsonUIErrors) {
+	path = append(append([]string{}, path...), cmd.Caption)
+
+	if cmd.anyError {
+		errs := make([]string, 0)
+		cmd.mutex.RLock()
+		for _, entry := range cmd.LogArray {
+			if entry.Error != nil {
+				errs = append(errs, neaterror.String("", entry.Error, false))
+			}
+		}
+		cmd.mutex.RUnlock()
+		*arr = append(*arr, jsonUIErrors{Path: path, Errors: errs})
+	}
+
+	for _, child := range cmd.Children {
+		j.getErrors(child.AsCommand(), path, arr)
+	}
+}
@@ -0,0 +1,58 @@
+package commandtree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONUI(t *testing.T) {
+	root := NewRootCommand("json test")
+	root.Add("good", NewFuncCommand(func(c *Command) {
+		c.Logf("all good")
+	}))
+	root.Add("bad", NewFuncCommand(func(c *Command) {
+		c.Errf("this failed")
+	}))
+
+	r := NewRunner(root, 2)
+	go r.Run(nil)
+
+	buf := bytes.NewBuffer(nil)
+	if err := JSONUI(root, buf); err == nil {
+		t.Error("expected an error from JSONUI")
+	}
+
+	events := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		evt := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			t.Fatalf("invalid json line '%v': %v", scanner.Text(), err)
+		}
+		events = append(events, evt)
+	}
+
+	count := make(map[string]int)
+	for _, evt := range events {
+		count[evt["event"].(string)]++
+		if evt["event"] == "log" && evt["message"] == "all good" {
+			count["goodlog"]++
+		}
+	}
+	if count["child_added"] != 3 {
+		t.Errorf("expected 3 child_added events, got %v", count["child_added"])
+	}
+	if count["goodlog"] != 1 {
+		t.Errorf("expected the 'all good' log event")
+	}
+
+	summary := events[len(events)-1]
+	if summary["event"] != "summary" || summary["success"] != false {
+		t.Fatalf("expected a failed summary as the last event. got: %v", summary)
+	}
+	if errs, ok := summary["errors"].([]interface{}); !ok || len(errs) != 1 {
+		t.Errorf("expected one error in summary. got: %v", summary["errors"])
+	}
+}
@@ -11,6 +11,8 @@ type Runner struct {
 	workChan    chan CommandNode
 	initialized map[string]bool
 	monitor     chan *MonitorEvent
+	limits      map[string]int // limit group => max commands running at the same time
+	running     map[string]int // limit group => commands running now
 	sync.Mutex
 }
 
@@ -20,6 +22,44 @@ func NewRunner(root CommandNode, threads int) *Runner {
 		threads:     threads,
 		initialized: make(map[string]bool),
 		monitor:     make(chan *MonitorEvent, 10000),
+		limits:      make(map[string]int),
+		running:     make(map[string]int),
+	}
+}
+
+// SetLimit sets the max number of commands in the given limit group (see
+// Command.Limits) that may run at the same time. A limit of 0 or less means no limit.
+func (r *Runner) SetLimit(group string, limit int) {
+	r.Lock()
+	defer r.Unlock()
+	if limit > 0 {
+		r.limits[group] = limit
+	} else {
+		delete(r.limits, group)
+	}
+}
+
+// acquire reserves a slot in each of the given limit groups. Returns false,
+// reserving nothing, if any of the groups are full.
+func (r *Runner) acquire(groups []string) bool {
+	r.Lock()
+	defer r.Unlock()
+	for _, group := range groups {
+		if limit, found := r.limits[group]; found && r.running[group] >= limit {
+			return false
+		}
+	}
+	for _, group := range groups {
+		r.running[group]++
+	}
+	return true
+}
+
+func (r *Runner) release(groups []string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, group := range groups {
+		r.running[group]--
 	}
 }
 
@@ -34,6 +74,14 @@ func (r *Runner) Run(monitor chan *MonitorEvent) bool {
 
 				cmd := command.AsCommand()
 				cmd.mutex.Lock()
+
+				// commands in a full limit group are left as ready, and will be
+				// queued again by the monitor thread.
+				if cmd.State == CommandStateReady && !r.acquire(cmd.Limits) {
+					cmd.mutex.Unlock()
+					continue
+				}
+
 				if cmd.State == CommandStateReady {
 					cmd.State = CommandStateRunning
 					if r.monitor != nil {
@@ -42,6 +90,7 @@ func (r *Runner) Run(monitor chan *MonitorEvent) bool {
 					}
 					cmd.mutex.Unlock()
 					command.Execute()
+					r.release(cmd.Limits)
 					cmd.mutex.Lock()
 					if cmd.State == CommandStateRunning {
 						cmd.State = CommandStateCompleted
@@ -109,6 +158,9 @@ func (r *Runner) check(cmd CommandNode, startable bool, parentID string) (bool,
 		done = (childrenDone || !childrenStartable) && done
 		noError = childrenNoError && noError
 	}
+	if c.IgnoreErrors {
+		noError = true
+	}
 
 	return done, noError
 }
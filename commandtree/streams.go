@@ -228,7 +228,7 @@ func (m *monitorEventReader) event(evt *MonitorEvent) {
 	switch evt.EventType {
 	case monitorEventChildAdded:
 		if _, found := m.lookup[evt.CommandID]; !found {
-			node := &Command{Caption: evt.Caption, State: CommandStateReady, RemoteCommand: true}
+			node := &Command{ID: evt.CommandID, Caption: evt.Caption, State: CommandStateReady, RemoteCommand: true} // the id of the command that was sent
 			if evt.ParentID != "" {
 				parent, parentFound := m.lookup[evt.ParentID]
 				if !parentFound {
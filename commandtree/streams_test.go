@@ -48,4 +48,18 @@ func TestStream(t *testing.T) {
 
 	ConsoleUI(newRoot)
 	fmt.Println("done.")
+
+	// the copies of the commands have the ids of the commands that were sent
+	sent := make(map[string]bool)
+	for _, c := range root.Children {
+		sent[c.AsCommand().ID] = true
+	}
+	if len(newRoot.Children) != len(root.Children) {
+		t.Fatalf("expected %v commands, got %v", len(root.Children), len(newRoot.Children))
+	}
+	for _, c := range newRoot.Children {
+		if !sent[c.AsCommand().ID] {
+			t.Errorf("expected the id of a command that was sent, got %v", c.AsCommand().ID)
+		}
+	}
 }
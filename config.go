@@ -27,6 +27,25 @@ func addErrors(errors *[]error, location string, errs []error) {
 	}
 }
 
+// findProjectRoot returns the nearest folder containing *.dogo files, starting
+// with dir and walking up the tree. Returns false if no such folder exists.
+func findProjectRoot(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		if files, err := filepath.Glob(filepath.Join(dir, "*.dogo")); err == nil && len(files) > 0 {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
 func buildConfig(path string) (config *schema.Config, errors []error) {
 	errors = nil
 
@@ -37,7 +56,8 @@ func buildConfig(path string) (config *schema.Config, errors []error) {
 	}
 
 	if len(files) == 0 {
-		addError(&errors, "", "Could not find any *.dogo files in the current directory")
+		dir, _ := filepath.Abs(path)
+		addError(&errors, "", "Could not find any *.dogo files in %v. Run dogo from the project folder (or a folder below it), or set it with --project or $DOGO_PROJECT", dir)
 		return
 	}
 
@@ -72,6 +92,10 @@ func buildConfig(path string) (config *schema.Config, errors []error) {
 	resourceGroupConstructor := make(map[string]*constructor.Constructor)
 	tunnelConstructor := constructor.New(&schema.Tunnel{}, config.TemplateSource.NewTemplate)
 	commandConstructor := constructor.New(&schema.Command{}, config.TemplateSource.NewTemplate)
+	rollingConstructor := constructor.New(&schema.Rolling{}, config.TemplateSource.NewTemplate)
+	parallelConstructor := constructor.New(&schema.Parallel{}, config.TemplateSource.NewTemplate)
+	hookConstructor := constructor.New(&schema.HookSettings{}, config.TemplateSource.NewTemplate)
+	notifyConstructor := constructor.New(&schema.Notify{}, config.TemplateSource.NewTemplate)
 	for _, manager := range registry.ModuleManagers {
 		if manager.ModulePrototype != nil {
 			moduleConstructor[manager.Name] = constructor.New(manager.ModulePrototype, config.TemplateSource.NewTemplate)
@@ -86,10 +110,10 @@ func buildConfig(path string) (config *schema.Config, errors []error) {
 	commandPrototypes := make(map[string]map[string]interface{}) // commandName => args for command.
 
 	// parse packages
-	parsePackages(&errors, config, configFiles, tunnelConstructor, commandConstructor, commandPrototypes)
+	parsePackages(&errors, config, configFiles, tunnelConstructor, commandConstructor, rollingConstructor, commandPrototypes)
 
 	// parse environments
-	parseEnvironments(&errors, config, configFiles, moduleConstructor, resourceConstructor, resourceGroupConstructor, commandConstructor, commandPrototypes)
+	parseEnvironments(&errors, config, configFiles, moduleConstructor, resourceConstructor, resourceGroupConstructor, commandConstructor, rollingConstructor, parallelConstructor, hookConstructor, notifyConstructor, commandPrototypes)
 
 	return
 }
@@ -123,7 +147,7 @@ func setTemplateGlobals(config *schema.Config, env *schema.Environment) {
 	}
 }
 
-func parsePackages(errors *[]error, config *schema.Config, configFiles map[string]map[string]interface{}, tunnelConstructor, commandConstructor *constructor.Constructor, commandPrototype map[string]map[string]interface{}) {
+func parsePackages(errors *[]error, config *schema.Config, configFiles map[string]map[string]interface{}, tunnelConstructor, commandConstructor, rollingConstructor *constructor.Constructor, commandPrototype map[string]map[string]interface{}) {
 	tunnelNames := make(map[string]bool)
 
 	for filename, file := range configFiles {
@@ -210,6 +234,16 @@ func parsePackages(errors *[]error, config *schema.Config, configFiles map[strin
 											}
 										}
 										break
+									case "rolling":
+										it, errs := rollingConstructor.Construct("rolling.", []map[string]interface{}{v8}, nil)
+										addErrors(errors, location, errs)
+										if rolling, ok := it.(*schema.Rolling); ok && len(errs) == 0 {
+											if pack.Rolling != nil {
+												addError(errors, location, "The package '%v' has more than one rolling block", packageName)
+											}
+											pack.Rolling = rolling
+										}
+										break
 									default:
 
 										// validate module name is registrered.
@@ -254,7 +288,7 @@ func parsePackages(errors *[]error, config *schema.Config, configFiles map[strin
 	return
 }
 
-func parseEnvironments(errors *[]error, config *schema.Config, configFiles map[string]map[string]interface{}, moduleConstructor, resourceConstructor map[string]*constructor.Constructor, resourceGroupConstructor map[string]*constructor.Constructor, commandConstructor *constructor.Constructor, commandPrototype map[string]map[string]interface{}) {
+func parseEnvironments(errors *[]error, config *schema.Config, configFiles map[string]map[string]interface{}, moduleConstructor, resourceConstructor map[string]*constructor.Constructor, resourceGroupConstructor map[string]*constructor.Constructor, commandConstructor, rollingConstructor, parallelConstructor, hookConstructor, notifyConstructor *constructor.Constructor, commandPrototype map[string]map[string]interface{}) {
 	for filename, file := range configFiles {
 		for name, v := range file {
 			location := filename
@@ -291,6 +325,30 @@ func parseEnvironments(errors *[]error, config *schema.Config, configFiles map[s
 							for providerName, providerConfig := range v5 {
 								location = filename + " -> environment." + environmentName + "." + providerName
 
+								// git guardrails
+								if providerName == "require_clean_git" {
+									if boolValue, ok := providerConfig.(bool); ok {
+										env.RequireCleanGit = boolValue
+									} else {
+										addError(errors, location, "require_clean_git must be true or false. Got: %v", providerConfig)
+									}
+									continue
+								}
+								if providerName == "allowed_branches" {
+									arr, ok := providerConfig.([]interface{})
+									for _, v := range arr {
+										if branch, isString := v.(string); isString {
+											env.AllowedBranches = append(env.AllowedBranches, branch)
+										} else {
+											ok = false
+										}
+									}
+									if !ok {
+										addError(errors, location, "allowed_branches must be a list of branch names, e.g. [\"main\"]. Got: %v", providerConfig)
+									}
+									continue
+								}
+
 								v6, ok := providerConfig.([]map[string]interface{})
 								if !ok {
 									if boolValue, ok := providerConfig.(bool); ok {
@@ -498,7 +556,29 @@ func parseEnvironments(errors *[]error, config *schema.Config, configFiles map[s
 											continue
 										}
 									}
-								} else if providerName == "before_deployment" || providerName == "after_deployment" {
+								} else if providerName == "rolling" {
+									it, errs := rollingConstructor.Construct("rolling.", v6, nil)
+									addErrors(errors, location, errs)
+									if rolling, ok := it.(*schema.Rolling); ok && len(errs) == 0 {
+										if env.Rolling != nil {
+											addError(errors, location, "The environment '%v' has more than one rolling block", environmentName)
+										}
+										env.Rolling = rolling
+									}
+								} else if providerName == "parallel" {
+									if env.Parallel != nil {
+										addError(errors, location, "The environment '%v' has more than one parallel block", environmentName)
+									}
+									env.Parallel = parseParallel(errors, location, parallelConstructor, v6)
+								} else if providerName == "notify" {
+									for _, m := range v6 { // every notify block is a webhook
+										it, errs := notifyConstructor.Construct("notify.", []map[string]interface{}{m}, nil)
+										addErrors(errors, location, errs)
+										if notify, ok := it.(*schema.Notify); ok && len(errs) == 0 {
+											env.Notify = append(env.Notify, notify)
+										}
+									}
+								} else if providerName == "before_deployment" || providerName == "after_deployment" || providerName == "before_server" || providerName == "after_server" {
 									location = filename + " -> environment." + environmentName + "." + providerName
 
 									v6, ok := providerConfig.([]map[string]interface{})
@@ -528,6 +608,19 @@ func parseEnvironments(errors *[]error, config *schema.Config, configFiles map[s
 												}
 											}
 
+											// the hook settings (order, on_failure, when_changed) are given with the command arguments
+											settings, errs := hookConstructor.Construct("", []map[string]interface{}{extraArgs}, nil)
+											addErrors(errors, location, errs)
+											hookSettings, ok := settings.(*schema.HookSettings)
+											if !ok || len(errs) > 0 {
+												continue
+											}
+											for _, moduleName := range hookSettings.WhenChanged {
+												if _, found := registry.ModuleManagers[moduleName]; !found {
+													addError(errors, location, "Unknown module '%v' in when_changed of %v", moduleName, commandName)
+												}
+											}
+
 											it, errs := commandConstructor.Construct("", []map[string]interface{}{
 												commandPrototype[commandName],
 												extraArgs,
@@ -543,6 +636,9 @@ func parseEnvironments(errors *[]error, config *schema.Config, configFiles map[s
 													CommandPackage:      pack.Name,
 													RunBeforeDeployment: providerName == "before_deployment",
 													RunAfterDeployment:  providerName == "after_deployment",
+													RunBeforeServer:     providerName == "before_server",
+													RunAfterServer:      providerName == "after_server",
+													HookSettings:        *hookSettings,
 												})
 											}
 										}
@@ -602,3 +698,40 @@ func findTunnel(config *schema.Config, tunnelName string) *schema.Tunnel {
 	}
 	return nil
 }
+
+// parseParallel parses a parallel block. Besides the settings of schema.Parallel,
+// the block can limit how many servers of a resource manager are provisioned at
+// the same time, e.g. 'linode = 2'.
+func parseParallel(errors *[]error, location string, parallelConstructor *constructor.Constructor, values []map[string]interface{}) *schema.Parallel {
+	settings := make(map[string]bool)
+	for _, field := range parallelConstructor.Fields() {
+		settings[field.Name] = true
+	}
+
+	managers := make(map[string]int)
+	for _, m := range values {
+		for key, value := range m {
+			if settings[key] {
+				continue
+			}
+			if _, found := registry.ResourceManagers[key]; !found {
+				addError(errors, location, "Unknown parallel setting '%v'. Must be one of provision, local, remote, commands or the name of a resource manager", key)
+				continue
+			}
+			limit, ok := value.(int)
+			if !ok || limit < 1 {
+				addError(errors, location, "The parallel limit for '%v' must be a number above 0. Got: %v", key, value)
+				continue
+			}
+			managers[key] = limit
+		}
+	}
+
+	it, errs := parallelConstructor.Construct("parallel.", values, nil)
+	addErrors(errors, location, errs)
+	if parallel, ok := it.(*schema.Parallel); ok && len(errs) == 0 {
+		parallel.Managers = managers
+		return parallel
+	}
+	return nil
+}
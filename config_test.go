@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/constructor"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestFindProjectRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "dogoproject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+
+	project := filepath.Join(root, "project")
+	subfolder := filepath.Join(project, "components", "webserver")
+	if err := os.MkdirAll(subfolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(project, "project.dogo"), []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{project, subfolder} {
+		found, ok := findProjectRoot(dir)
+		if !ok || found != project {
+			t.Errorf("findProjectRoot(%v): expected %v, got %v (found: %v)", dir, project, found, ok)
+		}
+	}
+
+	if found, ok := findProjectRoot(root); ok {
+		t.Errorf("findProjectRoot(%v): expected no project, got %v", root, found)
+	}
+}
+
+func TestParseParallel(t *testing.T) {
+	c := constructor.New(&schema.Parallel{}, nil)
+
+	var errs []error
+	parallel := parseParallel(&errs, "test", c, []map[string]interface{}{{"remote": 3, "localhost": 2}})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if parallel.Remote != 3 || parallel.Commands != 5 || parallel.Managers["localhost"] != 2 {
+		t.Errorf("unexpected parallel settings: %+v", parallel)
+	}
+
+	errs = nil
+	parseParallel(&errs, "test", c, []map[string]interface{}{{"bogus": 2, "localhost": 0}})
+	if len(errs) != 2 {
+		t.Errorf("expected errors for the unknown setting and the zero limit, got %v", errs)
+	}
+}
@@ -47,7 +47,9 @@ type constructorField struct {
 	isBool          bool
 	isStringArray   bool
 	isTemplateArray bool
+	isTemplateMap   bool
 	isTemplate      bool
+	block           *Constructor // for nested blocks (pointers to structs)
 	defaultValue    string
 	defaultEnvValue string
 }
@@ -77,12 +79,24 @@ func New(prototype interface{}, templateCreator TemplateCreator) *Constructor {
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
 		if !field.Anonymous {
-			// find the lowercase field name.
+			// find the lowercase field name (or the name given in the 'name' tag).
+			// fields named "-" are set by the caller and can't be set from config.
 			lowname := strings.ToLower(field.Name)
+			if name := field.Tag.Get("name"); name == "-" {
+				continue
+			} else if name != "" {
+				lowname = name
+			}
 
 			// add the field
 			typestring := field.Type.String()
 
+			// nested blocks
+			var block *Constructor
+			if field.PkgPath == "" && field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+				block = New(reflect.New(field.Type.Elem()).Interface(), templateCreator)
+			}
+
 			c.fields = append(c.fields, &constructorField{
 				field:           field,
 				fieldIndex:      i,
@@ -94,6 +108,8 @@ func New(prototype interface{}, templateCreator TemplateCreator) *Constructor {
 				isBool:          typestring == "bool",
 				isStringArray:   typestring == "[]string",
 				isTemplateArray: typestring == "[]schema.Template",
+				isTemplateMap:   typestring == "map[string]schema.Template",
+				block:           block,
 				required:        field.Tag.Get("required") == "true",
 				description:     field.Tag.Get("description"),
 				defaultValue:    field.Tag.Get("default"),
@@ -105,6 +121,55 @@ func New(prototype interface{}, templateCreator TemplateCreator) *Constructor {
 	return c
 }
 
+// Field describes a property that can be set on the constructed type
+type Field struct {
+	Name        string
+	Type        string
+	Required    bool
+	Default     string
+	DefaultEnv  string
+	Description string
+}
+
+// Fields returns the properties that can be set on the constructed type, in declaration order
+func (c *Constructor) Fields() []Field {
+	fields := make([]Field, 0, len(c.fields))
+	for _, field := range c.fields {
+		if field.field.PkgPath != "" {
+			continue // unexported fields can't be set from config.
+		}
+
+		typ := field.typestring
+		if field.isTemplate {
+			typ = "template"
+		} else if field.isTemplateArray {
+			typ = "[]template"
+		} else if field.isTemplateMap {
+			typ = "map[string]template"
+		} else if field.block != nil {
+			typ = "block"
+		}
+
+		fields = append(fields, Field{
+			Name:        field.lowname,
+			Type:        typ,
+			Required:    field.required,
+			Default:     field.defaultValue,
+			DefaultEnv:  field.defaultEnvValue,
+			Description: field.description,
+		})
+
+		// the properties of nested blocks are named 'block.property'
+		if field.block != nil {
+			for _, f := range field.block.Fields() {
+				f.Name = field.lowname + "." + f.Name
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
 func (c *Constructor) Construct(path string, values []map[string]interface{}, templateVars map[string]interface{}) (interface{}, []error) {
 	var errors []error
 	instance := reflect.New(c.typ)
@@ -194,6 +259,31 @@ func (c *Constructor) Construct(path string, values []map[string]interface{}, te
 					}
 					fieldValue = newArr
 				}
+			} else if field.isTemplateMap {
+				// maps are written as blocks, e.g. 'env { PORT = 8080 }'. numbers and bools are used as strings.
+				blocks, ok := fieldValue.([]map[string]interface{})
+				if !ok {
+					errors = append(errors, c.errf(values, "Property '%v' must be a block of key = value pairs. Got: %v (%T)", path+field.lowname, fieldValue, fieldValue))
+					continue
+				}
+				newMap := make(map[string]schema.Template)
+				for _, block := range blocks {
+					for key, v := range block {
+						switch v.(type) {
+						case string, int, bool:
+						default:
+							errors = append(errors, c.errf(values, "Property '%v.%v' must be of type string. Got: %v (%T)", path+field.lowname, key, v, v))
+							continue
+						}
+						template, err := c.templateCreator(path+field.lowname+"."+key, fmt.Sprint(v), templateVars)
+						if err != nil {
+							errors = append(errors, c.errf(values, "Property '%v.%v' was not a valid template: %v", path+field.lowname, key, err.Error()))
+							continue
+						}
+						newMap[key] = template
+					}
+				}
+				fieldValue = newMap
 			} else if field.isInt {
 				if _, ok := fieldValue.(int); !ok {
 					errors = append(errors, c.errf(values, "Property '%v' must be of type int. Got: %v (%T)", path+field.lowname, fieldValue, fieldValue))
@@ -224,6 +314,18 @@ func (c *Constructor) Construct(path string, values []map[string]interface{}, te
 					errors = append(errors, c.errf(values, "Property '%v' must be of type []string. Got: %v (%T)", path+field.lowname, fieldValue, fieldValue))
 					continue
 				}
+			} else if field.block != nil {
+				blocks, ok := fieldValue.([]map[string]interface{})
+				if !ok {
+					errors = append(errors, c.errf(values, "Property '%v' must be a block. Got: %v (%T)", path+field.lowname, fieldValue, fieldValue))
+					continue
+				}
+				it, errs := field.block.Construct(path+field.lowname+".", blocks, templateVars)
+				if len(errs) > 0 {
+					errors = append(errors, errs...)
+					continue
+				}
+				fieldValue = it
 			} else {
 				panic("Unknown field type!")
 			}
@@ -87,6 +87,132 @@ func TestConstructor(t *testing.T) {
 	}
 }
 
+type fieldsExample struct {
+	Image   schema.Template   `required:"true" description:"The image to run"`
+	Port    int               `default:"80"`
+	Token   string            `default_env:"TOKEN"`
+	Options []schema.Template `description:"Extra options"`
+	Retries int               `name:"max_retries"`
+	hidden  string
+}
+
+func TestFields(t *testing.T) {
+	fields := New(&fieldsExample{}, nil).Fields()
+	expected := []Field{
+		{Name: "image", Type: "template", Required: true, Description: "The image to run"},
+		{Name: "port", Type: "int", Default: "80"},
+		{Name: "token", Type: "string", DefaultEnv: "TOKEN"},
+		{Name: "options", Type: "[]template", Description: "Extra options"},
+		{Name: "max_retries", Type: "int"},
+	}
+
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %v fields, got %v: %v", len(expected), len(fields), fields)
+	}
+	for i, field := range fields {
+		if field != expected[i] {
+			t.Errorf("field %v: expected %+v, got %+v", i, expected[i], field)
+		}
+	}
+}
+
+type nameTagExample struct {
+	Retries int `name:"max_retries"`
+	Port    int
+	Extra   map[string]int `name:"-"`
+}
+
+func TestNameTag(t *testing.T) {
+	it, errs := New(&nameTagExample{}, nil).Construct("", []map[string]interface{}{
+		{"max_retries": 3, "port": 8080, "extra": 1},
+	}, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if example := it.(*nameTagExample); example.Retries != 3 || example.Port != 8080 {
+		t.Errorf("expected retries=3 and port=8080, got %+v", example)
+	}
+	if fields := New(&nameTagExample{}, nil).Fields(); len(fields) != 2 {
+		t.Errorf("expected the field named '-' to be skipped, got %v", fields)
+	}
+}
+
+type blockExample struct {
+	Name  string
+	Check *checkExample `description:"A nested block"`
+}
+
+type checkExample struct {
+	Port    int `required:"true"`
+	Retries int `default:"3"`
+}
+
+func TestBlocks(t *testing.T) {
+	c := New(&blockExample{}, nil)
+	it, errs := c.Construct("", []map[string]interface{}{
+		{"name": "web", "check": []map[string]interface{}{{"port": 80}}},
+	}, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	example := it.(*blockExample)
+	if example.Check == nil || example.Check.Port != 80 || example.Check.Retries != 3 {
+		t.Fatalf("nested block not constructed correctly: %+v", example.Check)
+	}
+
+	// missing block is nil, errors in block are reported with the path
+	it, errs = c.Construct("", []map[string]interface{}{{"name": "web"}}, nil)
+	if len(errs) > 0 || it.(*blockExample).Check != nil {
+		t.Errorf("expected no block and no errors, got %+v (errors: %v)", it, errs)
+	}
+	_, errs = c.Construct("", []map[string]interface{}{{"check": []map[string]interface{}{{"retries": 1}}}}, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "'check.port'") {
+		t.Errorf("expected missing check.port error, got %v", errs)
+	}
+
+	fields := c.Fields()
+	if len(fields) != 4 || fields[1].Type != "block" || fields[2].Name != "check.port" || !fields[2].Required || fields[3].Name != "check.retries" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+}
+
+type mapExample struct {
+	Env map[string]schema.Template
+}
+
+func TestTemplateMaps(t *testing.T) {
+	set := jet.NewSet(func(w io.Writer, b []byte) { w.Write(b) })
+	c := New(&mapExample{}, func(location string, templateStr string, templateVars map[string]interface{}) (schema.Template, error) {
+		templ, err := set.ParseInline(location, templateStr)
+		if err != nil {
+			return nil, err
+		}
+		return &template{template: templ, originalTemplate: templateStr}, nil
+	})
+
+	it, errs := c.Construct("", []map[string]interface{}{
+		{"env": []map[string]interface{}{{"HOST": "db", "PORT": 5432}}},
+	}, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	env := it.(*mapExample).Env
+	if len(env) != 2 {
+		t.Fatalf("expected 2 entries, got %v", env)
+	}
+	if port, err := env["PORT"].Render(nil); err != nil || port != "5432" {
+		t.Errorf("expected PORT to render as 5432, got %v (%v)", port, err)
+	}
+
+	_, errs = c.Construct("", []map[string]interface{}{{"env": "HOST=db"}}, nil)
+	if len(errs) != 1 {
+		t.Errorf("expected an error for a map given as a string, got %v", errs)
+	}
+	if fields := c.Fields(); len(fields) != 1 || fields[0].Type != "map[string]template" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+}
+
 type template struct {
 	originalTemplate string
 	template         *jet.Template
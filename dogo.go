@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"io/ioutil"
@@ -19,16 +20,62 @@ import (
 
 var config *schema.Config
 
+// exitCodeChangesPending is the exit code used when a read-only command
+// (such as 'dogo deploy --dryrun' or 'dogo status') finds changes that would be deployed.
+const exitCodeChangesPending = 2
+
+// exitCodeCheckFailed is the exit code used when a read-only command could not
+// check every server (e.g. it couldn't connect), so it can't tell what would change.
+const exitCodeCheckFailed = 3
+
+// output formats for --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var flagOutput = outputText
 var flagAllowDecommission = false
+var flagDryRun = false
+var flagRollbackTo = 0
+var flagParallel = defaultParallel
+var flagUnlockForce = false
+var flagDeployOnly []string
+var flagDeployExclude []string
+var flagDeployPackages []string
+var flagLogsFollow = false
+var flagLogsLines = 100
+var flagLogsIgnoreCase = false
+var flagContextFormat = "yaml"
+var flagContextRemote = false
+var flagSyntaxMarkdown = false
 var flagVault = ""
 var flagKeyStrength = ""
 var flagCredentialsStore = ""
+var flagProject = ""
+
+// invocationDir is the folder dogo was started in. dogo runs from the project
+// folder, so paths given on the command line must be resolved against this.
+var invocationDir = ""
 
 func main() {
 	// required for serilization
 	registry.GobRegister()
 
-	// read config from current folder
+	// find the project folder, and run from there so relative paths in the config resolve against it.
+	var err error
+	invocationDir, err = os.Getwd()
+	if err != nil {
+		fmt.Println(neaterror.String("", err, term.IsTerminal))
+		os.Exit(-1)
+	}
+	projectDir := projectFolder(os.Args[1:])
+	if err := os.Chdir(projectDir); err != nil {
+		fmt.Println(neaterror.String("", fmt.Errorf("Could not use project folder %v: %v", projectDir, err), term.IsTerminal))
+		os.Exit(-1)
+	}
+
+	// read config from the project folder
 	var errs []error
 	config, errs = buildConfig("")
 	if errs != nil {
@@ -37,15 +84,50 @@ func main() {
 	}
 
 	// configure command flags
+	DogoCmd.PersistentFlags().StringVar(&flagProject, "project", "", "the project folder containing the *.dogo files. Defaults to $DOGO_PROJECT, or the nearest folder with *.dogo files")
+	DogoCmd.PersistentFlags().StringVar(&flagOutput, "output", outputText, "the output format for progress of deploy, build and package commands: text or json (newline delimited events)")
 	DogoCmd.PersistentFlags().StringVar(&flagCredentialsStore, "credentials", defaultCredStore(), "the credentials store to read/store the passphrase in so you don't have to re-enter it every time.")
 	DogoDeployCommand.PersistentFlags().BoolVar(&flagAllowDecommission, "allowdecommission", false, "if true, will remove unused resources/servers from the target environment")
+	DogoDeployCommand.PersistentFlags().BoolVar(&flagDryRun, "dryrun", false, "if true, will only print the commands required to deploy the environment. Exits with code 2 if any changes are pending, or 3 if they could not be calculated for every server")
+	DogoDeployCommand.PersistentFlags().IntVar(&flagParallel, "parallel", defaultParallel, "the max number of servers deployed at the same time. See the 'parallel' environment block for finer grained limits")
+	DogoDeployCommand.PersistentFlags().StringSliceVar(&flagDeployOnly, "only", nil, "only deploy to servers with names matching these patterns, e.g. 'web_*'")
+	DogoDeployCommand.PersistentFlags().StringSliceVar(&flagDeployExclude, "exclude", nil, "don't deploy to servers with names matching these patterns")
+	DogoDeployCommand.PersistentFlags().StringSliceVar(&flagDeployPackages, "package", nil, "only deploy to servers with these packages")
+	DogoRollbackCommand.PersistentFlags().IntVar(&flagRollbackTo, "to", 0, "the number of the deployment to roll back to (see 'dogo history'). Defaults to the deployment before the current one")
+	DogoRollbackCommand.PersistentFlags().BoolVar(&flagDryRun, "dryrun", false, "if true, will only print the commands required to roll back. Exits with code 2 if any changes are pending, or 3 if they could not be calculated for every server")
+	DogoRollbackCommand.PersistentFlags().IntVar(&flagParallel, "parallel", defaultParallel, "the max number of servers rolled back at the same time")
+	DogoStatusCommand.PersistentFlags().IntVar(&flagParallel, "parallel", defaultParallel, "the max number of servers checked at the same time")
+	DogoStatusCommand.PersistentFlags().StringSliceVar(&flagDeployOnly, "only", nil, "only check servers with names matching these patterns, e.g. 'web_*'")
+	DogoStatusCommand.PersistentFlags().StringSliceVar(&flagDeployExclude, "exclude", nil, "don't check servers with names matching these patterns")
+	DogoStatusCommand.PersistentFlags().StringSliceVar(&flagDeployPackages, "package", nil, "only check servers with these packages")
+	DogoUnlockCommand.PersistentFlags().BoolVar(&flagUnlockForce, "force", false, "if true, will also remove locks taken by other users or machines")
+	DogoLogsCommand.PersistentFlags().BoolVarP(&flagLogsFollow, "tail", "t", false, "keep following the logs as new lines are written")
+	DogoLogsCommand.PersistentFlags().IntVarP(&flagLogsLines, "lines", "n", 100, "number of lines to read from the end of each log. 0 means the entire log")
+	DogoLogsCommand.PersistentFlags().BoolVarP(&flagLogsIgnoreCase, "ignorecase", "i", false, "ignore case when matching SEARCH")
+	DogoContextCommand.PersistentFlags().StringVarP(&flagContextFormat, "format", "f", "yaml", "output format: yaml or json")
+	DogoContextCommand.PersistentFlags().BoolVarP(&flagContextRemote, "remote", "r", false, "gather state from the servers (network interfaces, etc.) before printing the context")
+	DogoSyntaxCommand.PersistentFlags().BoolVarP(&flagSyntaxMarkdown, "markdown", "m", false, "output the documentation as markdown")
+	DogoCmd.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{outputText, outputJSON}, cobra.ShellCompDirectiveNoFileComp
+	})
+	DogoContextCommand.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"yaml", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
 	DogoVaultCommand.PersistentFlags().StringVarP(&flagVault, "vault", "v", "secrets.vault", "vault filename")
 	DogoVaultCreateCommand.PersistentFlags().StringVar(&flagKeyStrength, "keystrength", "sensitive", "the strength used to scrypt the passphrase. (interactive:fast, sensitive:slower, more secure)")
 
 	// build corbra-command tree
 	DogoCmd.AddCommand(DogoBuildCmd)
 	DogoCmd.AddCommand(DogoDeployCommand)
+	DogoCmd.AddCommand(DogoRollbackCommand)
+	DogoCmd.AddCommand(DogoHistoryCommand)
+	DogoCmd.AddCommand(DogoUnlockCommand)
+	DogoCmd.AddCommand(DogoStatusCommand)
+	DogoCmd.AddCommand(DogoLogsCommand)
 	DogoCmd.AddCommand(DogoSSHCommand)
+	DogoCmd.AddCommand(DogoSCPCommand)
+	DogoCmd.AddCommand(DogoContextCommand)
+	DogoCmd.AddCommand(DogoSyntaxCommand)
 	DogoCmd.AddCommand(DogoTunnelCommand)
 	DogoCmd.AddCommand(DogoVaultCommand)
 	DogoVaultCommand.AddCommand(DogoVaultCreateCommand)
@@ -70,6 +152,40 @@ func main() {
 	}
 }
 
+// projectFolder finds the project folder from the --project flag (which has to be
+// read before cobra parses flags, as the commands depend on the config), the
+// DOGO_PROJECT environment variable, or by searching up from the current folder.
+func projectFolder(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--project=") {
+			return strings.TrimPrefix(arg, "--project=")
+		}
+		if arg == "--project" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	if dir := os.Getenv("DOGO_PROJECT"); dir != "" {
+		return dir
+	}
+
+	if dir, found := findProjectRoot(invocationDir); found {
+		return dir
+	}
+	return invocationDir
+}
+
+// invocationPath resolves a path given on the command line against the folder dogo was started in
+func invocationPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(invocationDir, path)
+}
+
 func createDogoCommand(name string, c *schema.Command, packageName string) *cobra.Command {
 	return &cobra.Command{
 		Use:   name + " ENVIRONMENT [target]",
@@ -90,6 +206,7 @@ func createDogoCommand(name string, c *schema.Command, packageName string) *cobr
 			dogoCommand(config, environment, name, c, packageName, forceTarget, []string{})
 			return nil
 		},
+		ValidArgsFunction: completePackageCommand(packageName),
 	}
 }
 
@@ -100,6 +217,12 @@ var DogoCmd = &cobra.Command{
 	Long: `Dogo is an opinionated and focused development and deployment 
 tool that tries to make the development and deployment for 
 multi-component projects easy as pie.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if flagOutput != outputText && flagOutput != outputJSON {
+			return fmt.Errorf("unknown output format: %v. Valid formats are: %v, %v", flagOutput, outputText, outputJSON)
+		}
+		return nil
+	},
 }
 
 // DogoBuildCmd represents the 'dogo build' command
@@ -117,8 +240,65 @@ var DogoBuildCmd = &cobra.Command{
 var DogoDeployCommand = &cobra.Command{
 	Use:     "deploy ENVIRONMENT",
 	Short:   "Deploy the given environment",
-	Example: "dogo deploy dev",
-	//Long:  `TODO`,
+	Example: "dogo deploy prod --only 'web_*' --exclude web_3",
+	Long: `Deploy the given environment.
+
+Use --only, --exclude and --package to deploy to some of the servers. The other
+servers are left alone, but templates still see the entire environment (e.g.
+'resourcesbypackage'). Values gathered from the servers themselves, such as
+'networkinterface', are only set for the servers being deployed to.
+
+Environments with 'require_clean_git = true' or 'allowed_branches = ["main"]'
+are only deployed from a clean working tree or one of the branches. The git
+commit, branch and dirty state are recorded on the containers as labels
+(dogo.git.commit, dogo.git.branch and dogo.git.dirty).`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("requires argument: ENVIRONMENT")
+		}
+		environment, found := config.Environments[args[0]]
+		if !found {
+			return fmt.Errorf("unknown environment: %v", args[0])
+		}
+
+		filter := deployFilter{only: flagDeployOnly, exclude: flagDeployExclude, packages: flagDeployPackages}
+		if err := filter.validate(config, environment); err != nil {
+			return err
+		}
+		if flagAllowDecommission && !filter.empty() {
+			return fmt.Errorf("--allowdecommission can't be used with --only, --exclude or --package")
+		}
+		if !flagDryRun {
+			if err := checkGitRules(environment, currentGit()); err != nil {
+				return err
+			}
+		}
+
+		result := dogoDeploy(config, environment, deployOptions{
+			allowDecommission: flagAllowDecommission,
+			dryRun:            flagDryRun,
+			parallel:          flagParallel,
+			filter:            filter,
+		})
+		if code := result.exitCode(); flagDryRun && code != 0 {
+			os.Exit(code)
+		}
+		return nil
+	},
+	ValidArgsFunction: completeEnvironments,
+}
+
+// DogoRollbackCommand represents the 'dogo rollback [env]' command
+var DogoRollbackCommand = &cobra.Command{
+	Use:     "rollback ENVIRONMENT",
+	Short:   "Redeploy a previous deployment of the given environment",
+	Example: "dogo rollback prod --to 12",
+	Long: `Redeploy the containers and firewall rules recorded by a previous successful
+deploy, without rebuilding images. By default the environment is rolled back to
+the deployment before the current one. Use 'dogo history' to list deployments.
+
+Deployment records are kept in .dogocache/deployments, and on each server.
+Files are not part of the record, so they're only checked to be unchanged.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
 			return fmt.Errorf("requires argument: ENVIRONMENT")
@@ -128,24 +308,156 @@ var DogoDeployCommand = &cobra.Command{
 			return fmt.Errorf("unknown environment: %v", args[0])
 		}
 
-		dogoDeploy(config, environment, flagAllowDecommission)
+		result, err := dogoRollback(config, environment, flagRollbackTo, deployOptions{dryRun: flagDryRun, parallel: flagParallel})
+		if err != nil {
+			return err
+		}
+		if code := result.exitCode(); flagDryRun && code != 0 {
+			os.Exit(code)
+		}
 		return nil
 	},
+	ValidArgsFunction: completeEnvironments,
+}
+
+// DogoHistoryCommand represents the 'dogo history [env]' command
+var DogoHistoryCommand = &cobra.Command{
+	Use:     "history ENVIRONMENT",
+	Short:   "List the recorded deployments of the given environment",
+	Example: "dogo history prod",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("requires argument: ENVIRONMENT")
+		}
+		environment, found := config.Environments[args[0]]
+		if !found {
+			return fmt.Errorf("unknown environment: %v", args[0])
+		}
+
+		return dogoHistory(config, environment)
+	},
+	ValidArgsFunction: completeEnvironments,
+}
+
+// DogoStatusCommand represents the 'dogo status [env]' command
+var DogoStatusCommand = &cobra.Command{
+	Use:     "status ENVIRONMENT",
+	Short:   "Check the servers of the given environment for drift from the configuration",
+	Example: "dogo status prod --only 'web_*'",
+	Long: `Check whether the servers of the given environment match the configuration,
+without changing anything. For each server the modules that have drifted are
+listed, with the commands a deploy would run to fix them.
+
+Exits with code 2 if any server has drifted, so it can be run from a cron job to
+alert on drift. Exits with code 3 if any server could not be checked (e.g. it
+couldn't be reached), even if others have drifted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("requires argument: ENVIRONMENT")
+		}
+		environment, found := config.Environments[args[0]]
+		if !found {
+			return fmt.Errorf("unknown environment: %v", args[0])
+		}
+
+		filter := deployFilter{only: flagDeployOnly, exclude: flagDeployExclude, packages: flagDeployPackages}
+		if err := filter.validate(config, environment); err != nil {
+			return err
+		}
+
+		if code := dogoStatus(config, environment, deployOptions{parallel: flagParallel, filter: filter}).exitCode(); code != 0 {
+			os.Exit(code)
+		}
+		return nil
+	},
+	ValidArgsFunction: completeEnvironments,
+}
+
+// DogoUnlockCommand represents the 'dogo unlock [env]' command
+var DogoUnlockCommand = &cobra.Command{
+	Use:     "unlock ENVIRONMENT",
+	Short:   "Remove the deploy lock of the given environment",
+	Example: "dogo unlock prod --force",
+	Long: `Remove the deploy lock of the given environment from its servers.
+
+A deploy locks the environment on each server, so two deploys of the same
+environment can't run at the same time. The lock is released when the deploy
+ends, but is left behind if dogo is killed. By default only locks taken by you
+on this machine are removed. Use --force to remove the locks of others.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("requires argument: ENVIRONMENT")
+		}
+		environment, found := config.Environments[args[0]]
+		if !found {
+			return fmt.Errorf("unknown environment: %v", args[0])
+		}
+
+		return dogoUnlock(config, environment, flagUnlockForce)
+	},
+	ValidArgsFunction: completeEnvironments,
+}
+
+// DogoLogsCommand represents the 'dogo logs [query] [search]' command
+var DogoLogsCommand = &cobra.Command{
+	Use:     "logs LOGQUERY [SEARCH]",
+	Short:   "Read, search and tail logs across servers and containers",
+	Example: "dogo logs prod.*.docker.memcached -t",
+	Long: `Read, search (grep) and tail logs from servers in the environment. Lines are
+prefixed with the name of the log they came from.
+
+Logs are named 'server.syslog' and 'server.docker.containername'.
+
+Valid LOGQUERY values:
+"env"                          -> All logs in the environment
+"env.server"                   -> All logs on the given server
+"env.memcached"                -> Any log with a name part matching 'memcached'
+"env.*.docker.*"               -> All docker container logs from all servers
+"env.server.docker.memcached"  -> The log of the memcached container on the given server
+
+SEARCH is a regular expression that lines must match to be printed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("requires argument: LOGQUERY")
+		}
+		parts := strings.Split(args[0], ".")
+		environment, found := config.Environments[parts[0]]
+		if !found {
+			return fmt.Errorf("unknown environment: %v", parts[0])
+		}
+
+		query := ""
+		if len(parts) > 1 {
+			query = args[0][len(parts[0])+1:]
+		}
+		search := ""
+		if len(args) == 2 {
+			search = args[1]
+		}
+
+		return dogoLogs(config, environment, query, search, flagLogsFollow, flagLogsLines, flagLogsIgnoreCase)
+	},
+	ValidArgsFunction: completeLogs,
 }
 
 // DogoSSHCommand represents the 'dogo ssh [server]' command
 var DogoSSHCommand = &cobra.Command{
-	Use:     "ssh SERVER",
-	Short:   "Connect and start an shell session via SSH on the given serve",
+	Use:     "ssh SERVER[.CONTAINER]",
+	Short:   "Connect and start an shell session via SSH on the given server or docker container",
 	Example: "dogo ssh prod.web_1",
-	//Long: `Connect and start an shell session via SSH on the given server Example: xsdogo ssh prod.web_1`,
+	Long: `Connect and start a shell session via SSH on the given server. If a container
+name is given, the shell is started inside that docker container on the server.
+
+Valid SERVER values:
+"env.server"            -> Start a shell on the given server
+"env.server.container"  -> Start a shell inside the given docker container on the server`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 1 {
-			return fmt.Errorf("requires argument: SERVER, which must be in the form 'environment.name'. Examples: 'dev.web', 'prod.sql03'")
+			return fmt.Errorf("requires argument: SERVER, which must be in the form 'environment.name' or 'environment.name.container'. Examples: 'dev.web', 'prod.sql03', 'prod.web.memcached'")
 		}
 		parts := strings.Split(args[0], ".")
-		if len(parts) != 2 {
-			return fmt.Errorf("argument SERVER must be in the form 'environment.name'. Examples: 'dev.web', 'prod.sql03'")
+		if len(parts) != 2 && len(parts) != 3 {
+			return fmt.Errorf("argument SERVER must be in the form 'environment.name' or 'environment.name.container'. Examples: 'dev.web', 'prod.sql03', 'prod.web.memcached'")
 		}
 
 		environment, found := config.Environments[parts[0]]
@@ -153,8 +465,100 @@ var DogoSSHCommand = &cobra.Command{
 			return fmt.Errorf("unknown environment: %v", args[0])
 		}
 
-		return dogoSSH(config, environment, parts[1])
+		container := ""
+		if len(parts) == 3 {
+			container = parts[2]
+		}
+
+		return dogoSSH(config, environment, parts[1], container)
+	},
+	ValidArgsFunction: completeSSH,
+}
+
+// DogoSCPCommand represents the 'dogo scp [source] [destination]' command
+var DogoSCPCommand = &cobra.Command{
+	Use:     "scp SOURCE DESTINATION",
+	Short:   "Copy files and folders to and from servers",
+	Example: "dogo scp ./config.json prod.web_1:/etc/app/",
+	Long: `Copy files and folders between the local machine and a server in an environment.
+Folders are copied recursively. If the server requires it, sudo is used for reading and writing.
+
+Remote paths are given as "environment.server:path". Examples:
+"dogo scp ./build prod.web_1:/opt/app"          -> Upload the local folder 'build' to /opt/app
+"dogo scp prod.web_1:/var/log/app.log ."        -> Download app.log into the current folder`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("requires arguments: SOURCE DESTINATION")
+		}
+
+		source, err := parseSCPLocation(config, args[0])
+		if err != nil {
+			return err
+		}
+		destination, err := parseSCPLocation(config, args[1])
+		if err != nil {
+			return err
+		}
+
+		return dogoSCP(config, source, destination)
+	},
+	ValidArgsFunction: completeSCP,
+}
+
+// DogoContextCommand represents the 'dogo context [environment]' command
+var DogoContextCommand = &cobra.Command{
+	Use:     "context ENVIRONMENT[.SERVER]",
+	Short:   "Print the variables available to templates",
+	Example: "dogo context prod.web_1 --remote",
+	Long: `Print the full set of variables available to {{ }} templates in the given
+environment, after they've been rendered. If a server is given, 'self' is set
+to that server, as it is when rendering the templates of its modules.
+
+Templates depending on remote state (such as self.networkinterface) can only be
+rendered after the state is gathered from the servers, which happens with --remote.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("requires argument: ENVIRONMENT[.SERVER]")
+		}
+		parts := strings.Split(args[0], ".")
+		if len(parts) > 2 {
+			return fmt.Errorf("argument must be in the form 'environment' or 'environment.server'. Examples: 'dev', 'prod.web_1'")
+		}
+
+		environment, found := config.Environments[parts[0]]
+		if !found {
+			return fmt.Errorf("unknown environment: %v", parts[0])
+		}
+
+		server := ""
+		if len(parts) == 2 {
+			server = parts[1]
+		}
+
+		return dogoContext(config, environment, server, flagContextRemote, flagContextFormat)
+	},
+	ValidArgsFunction: completeServers,
+}
+
+// DogoSyntaxCommand represents the 'dogo syntax [name]' command
+var DogoSyntaxCommand = &cobra.Command{
+	Use:     "syntax [MODULE|RESOURCE]",
+	Short:   "Show the properties available for modules and resources in config files",
+	Example: "dogo syntax docker",
+	Long: `Show the properties that can be set on each module and resource in .dogo config
+files, along with their types, default values and descriptions. If a module or
+resource name is given, only that is shown. Use --markdown to generate reference documentation.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("too many arguments. Expected at most one module or resource name")
+		}
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		return dogoSyntax(name, flagSyntaxMarkdown)
 	},
+	ValidArgsFunction: completeSyntax,
 }
 
 // DogoTunnelCommand represents the 'dogo tunnel [query]' command
@@ -189,6 +593,7 @@ Valid TUNNELQUERY values:
 		dogoTunnel(config, enviroment, query)
 		return nil
 	},
+	ValidArgsFunction: completeTunnels,
 }
 
 // DogoVaultCommand represents the 'dogo vault' command
@@ -321,7 +726,7 @@ var DogoVaultSetFileCommand = &cobra.Command{
 		if len(args) != 2 {
 			return fmt.Errorf("requires two arguments: KEY FILENAME")
 		}
-		bytes, err := ioutil.ReadFile(args[1])
+		bytes, err := ioutil.ReadFile(invocationPath(args[1]))
 		if err != nil {
 			return err
 		}
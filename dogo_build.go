@@ -86,7 +86,7 @@ func dogoBuild(config *schema.Config) {
 	// Run!
 	r := commandtree.NewRunner(buildTasks, 10)
 	go r.Run(nil)
-	commandtree.ConsoleUI(buildTasks)
+	runUI(buildTasks)
 
 	// return error if this didn't work.
 	for _, cmd := range buildTasks.Children {
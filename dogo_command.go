@@ -32,7 +32,7 @@ func dogoCommand(config *schema.Config, environment *schema.Environment, command
 	} else {
 		r := commandtree.NewRunner(root, 10)
 		go r.Run(nil)
-		commandtree.ConsoleUI(root)
+		runUI(root)
 	}
 }
 
@@ -174,10 +174,14 @@ type packageCommand struct {
 	connection         schema.ServerConnection
 	requireRemoteState bool
 	tunnels            map[string]*schema.Tunnel // tunnelname => tunnel
+	vars               map[string]interface{}    // extra template variables, e.g. the server deployed for before/after_server hooks
 }
 
 func (c *packageCommand) getVars(tunnels map[string]*tunnelInfo) map[string]interface{} {
 	vars := make(map[string]interface{})
+	for k, v := range c.vars {
+		vars[k] = v
+	}
 	vars["tunnel"] = tunnels
 	vars["self"] = c.resource.Data
 	return vars
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/spf13/cobra"
+)
+
+// completeSegments completes one segment of a dotted name at a time, so
+// "p" completes to "prod." and "prod.w" completes to "prod.web_1".
+// Candidates are truncated after the first separator following toComplete.
+func completeSegments(candidates []string, toComplete string, separators string) ([]string, cobra.ShellCompDirective) {
+	directive := cobra.ShellCompDirectiveNoFileComp
+	seen := make(map[string]bool)
+	completions := make([]string, 0)
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, toComplete) {
+			continue
+		}
+		if i := strings.IndexAny(candidate[len(toComplete):], separators); i != -1 {
+			candidate = candidate[:len(toComplete)+i+1]
+			directive |= cobra.ShellCompDirectiveNoSpace
+		}
+		if !seen[candidate] {
+			seen[candidate] = true
+			completions = append(completions, candidate)
+		}
+	}
+	sort.Strings(completions)
+	return completions, directive
+}
+
+// completeEnvironments completes the first argument with environment names
+func completeEnvironments(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeSegments(environmentCandidates(), toComplete, "")
+}
+
+// completeServers completes 'env' or 'env.server'
+func completeServers(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	candidates := environmentCandidates()
+	for _, env := range config.Environments {
+		candidates = append(candidates, serverCandidates(env, "")...)
+	}
+	return completeSegments(candidates, toComplete, ".")
+}
+
+// completeSSH completes 'env.server' and 'env.server.container'
+func completeSSH(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	candidates := make([]string, 0)
+	for _, env := range config.Environments {
+		for _, name := range sortKeys(env.Resources) {
+			res := env.Resources[name]
+			if _, ok := res.Resource.(schema.ServerResource); !ok {
+				continue
+			}
+			candidates = append(candidates, env.Name+"."+name)
+			if strings.HasPrefix(toComplete, env.Name+"."+name+".") {
+				for _, container := range dockerContainerNames(res) {
+					candidates = append(candidates, env.Name+"."+name+"."+container)
+				}
+			}
+		}
+	}
+	return completeSegments(candidates, toComplete, ".")
+}
+
+// completeTunnels completes the tunnel queries described in 'dogo tunnel --help'
+func completeTunnels(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	candidates := environmentCandidates()
+	for _, env := range config.Environments {
+		for _, name := range sortKeys(env.Resources) {
+			res := env.Resources[name]
+			if _, ok := res.Resource.(schema.ServerResource); !ok {
+				continue
+			}
+			candidates = append(candidates, env.Name+"."+name)
+			for packageName := range res.Packages {
+				if pack, found := config.Packages[packageName]; found {
+					for tunnelName := range pack.Tunnels {
+						candidates = append(candidates, env.Name+"."+tunnelName, env.Name+"."+name+"."+tunnelName)
+					}
+				}
+			}
+		}
+	}
+	return completeSegments(candidates, toComplete, ".")
+}
+
+// completeLogs completes the log queries described in 'dogo logs --help'
+func completeLogs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	candidates := environmentCandidates()
+	for _, env := range config.Environments {
+		if !strings.HasPrefix(toComplete, env.Name+".") {
+			continue
+		}
+		for _, name := range sortKeys(env.Resources) {
+			for _, source := range getLogSources(env.Resources[name], false, 0) {
+				candidates = append(candidates, env.Name+"."+source.name)
+			}
+		}
+	}
+	return completeSegments(candidates, toComplete, ".")
+}
+
+// completeSCP completes 'env.server:' for remote paths, and falls back
+// to file completion for local paths.
+func completeSCP(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 1 || strings.Contains(toComplete, ":") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	candidates := make([]string, 0)
+	for _, env := range config.Environments {
+		candidates = append(candidates, serverCandidates(env, ":")...)
+	}
+	completions, directive := completeSegments(candidates, toComplete, ".:")
+	if len(completions) == 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return completions, directive
+}
+
+// completeSyntax completes module and resource names
+func completeSyntax(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	candidates := make([]string, 0)
+	for _, block := range syntaxBlocks() {
+		candidates = append(candidates, block.name)
+	}
+	return completeSegments(candidates, toComplete, "")
+}
+
+// completePackageCommand completes the environment and target of commands defined in packages
+func completePackageCommand(packageName string) func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return completeSegments(environmentCandidates(), toComplete, "")
+		case 1:
+			candidates := make([]string, 0)
+			if env, found := config.Environments[args[0]]; found {
+				for _, res := range env.ResourcesByPackage[packageName] {
+					candidates = append(candidates, res.Name)
+				}
+			}
+			return completeSegments(candidates, toComplete, "")
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func environmentCandidates() []string {
+	candidates := make([]string, 0, len(config.Environments))
+	for name := range config.Environments {
+		candidates = append(candidates, name)
+	}
+	return candidates
+}
+
+// serverCandidates returns 'env.server' + suffix for each server in the environment
+func serverCandidates(env *schema.Environment, suffix string) []string {
+	candidates := make([]string, 0, len(env.Resources))
+	for _, name := range sortKeys(env.Resources) {
+		if _, ok := env.Resources[name].Resource.(schema.ServerResource); ok {
+			candidates = append(candidates, env.Name+"."+name+suffix)
+		}
+	}
+	return candidates
+}
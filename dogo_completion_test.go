@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCompleteSegments(t *testing.T) {
+	candidates := []string{"prod.web_1", "prod.web_1.memcached", "prod.web_2", "dev.web_1"}
+
+	tests := []struct {
+		toComplete string
+		expected   []string
+		noSpace    bool
+	}{
+		{"", []string{"dev.", "prod."}, true},
+		{"pr", []string{"prod."}, true},
+		{"prod.", []string{"prod.web_1", "prod.web_1.", "prod.web_2"}, true},
+		{"prod.web_2", []string{"prod.web_2"}, false},
+		{"prod.web_1.", []string{"prod.web_1.memcached"}, false},
+		{"staging", []string{}, false},
+	}
+
+	for _, test := range tests {
+		completions, directive := completeSegments(candidates, test.toComplete, ".")
+		if !reflect.DeepEqual(completions, test.expected) {
+			t.Errorf("completing '%v': expected %v, got %v", test.toComplete, test.expected, completions)
+		}
+		if noSpace := directive&cobra.ShellCompDirectiveNoSpace != 0; noSpace != test.noSpace {
+			t.Errorf("completing '%v': expected nospace=%v, got %v", test.toComplete, test.noSpace, noSpace)
+		}
+	}
+}
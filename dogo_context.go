@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func dogoContext(config *schema.Config, environment *schema.Environment, server string, remote bool, format string) error {
+	if format != "yaml" && format != "json" {
+		return fmt.Errorf("Unknown format '%v'. Valid formats are: yaml, json", format)
+	}
+
+	setTemplateGlobals(config, environment)
+
+	// find server
+	var targetResource *schema.Resource
+	if server != "" {
+		res, found := environment.Resources[server]
+		if !found {
+			return fmt.Errorf("Unknown server: %v", server)
+		}
+		targetResource = res
+	}
+
+	// gather remote state (network interfaces, etc.) from the servers
+	if remote {
+		if err := gatherContextState(config, environment, targetResource); err != nil {
+			return err
+		}
+	}
+
+	// build the context as seen by templates.
+	context := make(map[string]interface{})
+	context["version"] = templateVersion
+	for k, v := range environment.Vars {
+		context[k] = v
+	}
+	resourcesByPackage := make(map[string][]interface{})
+	for p, resArr := range environment.ResourcesByPackage {
+		arr := make([]interface{}, 0, len(resArr))
+		for _, res := range resArr {
+			arr = append(arr, res.Data)
+		}
+		resourcesByPackage[p] = arr
+	}
+	context["resourcesbypackage"] = resourcesByPackage
+	resources := make([]interface{}, 0, len(environment.Resources))
+	for _, name := range sortKeys(environment.Resources) {
+		resources = append(resources, environment.Resources[name].Data)
+	}
+	context["resources"] = resources
+	if targetResource != nil {
+		context["self"] = targetResource.Data
+	}
+
+	// print it
+	tree := contextValue(reflect.ValueOf(context))
+	var output []byte
+	var err error
+	if format == "json" {
+		output, err = json.MarshalIndent(tree, "", "  ")
+		output = append(output, '\n')
+	} else {
+		output, err = yaml.Marshal(tree)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(output))
+
+	return nil
+}
+
+// gatherContextState reads the state of the given server (or all servers if nil),
+// so templates depending on remote state can be expanded. It's read-only: servers
+// aren't provisioned, and the dogoagent isn't installed or upgraded.
+func gatherContextState(config *schema.Config, environment *schema.Environment, target *schema.Resource) error {
+	root := commandtree.NewRootCommand("Gathering remote state")
+	for _, name := range sortKeys(environment.Resources) {
+		res := environment.Resources[name]
+		if target != nil && res != target {
+			continue
+		}
+		server, ok := res.Resource.(schema.ServerResource)
+		if !ok {
+			continue
+		}
+
+		name := name
+		var node commandtree.CommandNode
+		node = commandtree.NewFuncCommand(func(c *commandtree.Command) { readContextState(name, res, server, node) })
+		root.Add(environment.Name+"."+name, node)
+	}
+
+	r := commandtree.NewRunner(root, 10)
+	go r.Run(nil)
+	commandtree.ConsoleUI(root)
+
+	for _, cmd := range root.Children {
+		if cmd.AsCommand().AnyError() {
+			return fmt.Errorf("Could not gather remote state")
+		}
+	}
+
+	// expand the templates again, now that the remote state is known
+	for _, res := range environment.Resources {
+		expandResourceTemplates(res, config)
+	}
+
+	return nil
+}
+
+// readContextState reads the state of the server into the data of the resource
+func readContextState(name string, res *schema.Resource, server schema.ServerResource, node commandtree.CommandNode) {
+	c := node.AsCommand()
+
+	// servers that aren't provisioned have no remote state. Reading it never provisions.
+	if !isProvisioned(name, res, c) {
+		return
+	}
+
+	connection, err := server.OpenConnection()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	defer connection.Close()
+
+	// the agent isn't installed or upgraded, since someone else might be deploying
+	if _, _, upgradeAgent, _ := readState(res, connection, false, true, node, c); upgradeAgent {
+		c.Errf("The dogoagent on %v is missing or outdated, so the remote state can't be read. Deploy to %v to upgrade it.", name, name)
+	}
+}
+
+// contextValue converts the value into a tree of plain maps, slices and values
+// that can be serialized. Templates are rendered, and structs become maps
+// keyed by field name (which is how templates access them.)
+func contextValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.CanInterface() {
+		if t, ok := v.Interface().(schema.Template); ok && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+			str, err := t.Render(nil)
+			if err != nil {
+				return fmt.Sprintf("<error: %v>", err)
+			}
+			return str
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return contextValue(v.Elem())
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i != v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			m[field.Name] = contextValue(v.Field(i))
+		}
+		return m
+	case reflect.Map:
+		m := make(map[string]interface{})
+		for _, k := range v.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = contextValue(v.MapIndex(k))
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		arr := make([]interface{}, 0, v.Len())
+		for i := 0; i != v.Len(); i++ {
+			arr = append(arr, contextValue(v.Index(i)))
+		}
+		return arr
+	}
+
+	return v.Interface()
+}
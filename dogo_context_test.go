@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestReadContextState(t *testing.T) {
+	provisioned := false
+	manager := &schema.ResourceManager{
+		Name: "fake",
+		Provision: func(group interface{}, resource interface{}, l schema.Logger) error {
+			provisioned = true
+			return nil
+		},
+		Lookup: func(group interface{}, resource interface{}, l schema.Logger) (bool, error) { return true, nil },
+	}
+	server := &fakeServer{connection: &fakeConnection{}}
+	res := newTestDeployCommand(manager, server).res
+
+	// the agent is missing, and isn't installed just to read the state
+	node := commandtree.NewFuncCommand(func(c *commandtree.Command) {})
+	readContextState("web", res, server, node)
+	if provisioned {
+		t.Errorf("expected the server to not be provisioned")
+	}
+	if !node.AsCommand().AnyError() {
+		t.Errorf("expected an error about the missing agent")
+	}
+	for _, command := range server.connection.commands {
+		if !strings.HasSuffix(command, schema.AgentPath+" exec") {
+			t.Errorf("expected only the agent to be run, got %v", command)
+		}
+	}
+}
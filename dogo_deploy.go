@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -33,7 +34,8 @@ const (
 	deployStepRemoteCommands                             // 5
 	deployStepAfterDeploymentCommands                    // 6
 	deployStepDecommission                               // 7
-	deployStepDone                                       // 8
+	deployStepRecordDeployment                           // 8
+	deployStepDone                                       // 9
 )
 
 func sortKeys(m map[string]*schema.Resource) []string {
@@ -45,15 +47,92 @@ func sortKeys(m map[string]*schema.Resource) []string {
 	return arr
 }
 
-func dogoDeploy(config *schema.Config, environment *schema.Environment, allowDecommission bool) {
+// deployOptions controls how dogoDeploy runs.
+type deployOptions struct {
+	allowDecommission bool
+	dryRun            bool         // stop after calculating commands and print the plan
+	rollback          *deployment  // deploy this previous deployment instead of the configuration
+	parallel          int          // max number of servers deployed at the same time. 0 means defaultParallel
+	filter            deployFilter // the servers to deploy to
+	status            bool         // with dryRun: print which servers and modules have drifted instead of the plan
+}
+
+// defaultParallel is the number of servers deployed at the same time, if not set with --parallel
+const defaultParallel = 10
+
+// limit groups (see commandtree.Runner.SetLimit) for the limits of the environment's parallel block
+const (
+	limitProvision     = "provision"
+	limitLocal         = "local"
+	limitRemote        = "remote"
+	limitManagerPrefix = "manager."
+)
+
+// newDeployRunner creates the runner for the deploy, with the concurrency limits of the environment.
+func newDeployRunner(deployTask commandtree.CommandNode, environment *schema.Environment, parallel int) *commandtree.Runner {
+	if parallel <= 0 {
+		parallel = defaultParallel
+	}
+	r := commandtree.NewRunner(deployTask, parallel)
+	if p := environment.Parallel; p != nil {
+		r.SetLimit(limitProvision, p.Provision)
+		r.SetLimit(limitLocal, p.Local)
+		r.SetLimit(limitRemote, p.Remote)
+		for manager, limit := range p.Managers {
+			r.SetLimit(limitManagerPrefix+manager, limit)
+		}
+	}
+	return r
+}
+
+// remoteThreads is the number of remote commands run at the same time on each server
+func remoteThreads(environment *schema.Environment) int {
+	if environment.Parallel != nil {
+		return environment.Parallel.Commands
+	}
+	return 5
+}
+
+// deployResult is the outcome of a deploy
+type deployResult struct {
+	changes bool // changes were (or, for dry runs, would be) made
+	failed  bool // a server failed, or its commands could not be calculated
+}
+
+// exitCode returns the exit code of a read-only command (such as a dry run) with the result
+func (r deployResult) exitCode() int {
+	switch {
+	case r.failed:
+		return exitCodeCheckFailed
+	case r.changes:
+		return exitCodeChangesPending
+	}
+	return 0
+}
+
+// dogoDeploy deploys the environment.
+func dogoDeploy(config *schema.Config, environment *schema.Environment, options deployOptions) deployResult {
 	// build template globals.
 	setTemplateGlobals(config, environment)
 
 	// create deploy commands
 	provisioningGroups := make(map[int][]*deployCommand)
 	provisioningGroupIds := make([]int, 0)
-	deployCommands := make(map[string]*deployCommand)
-	deployTask := commandtree.NewRootCommand("Deploying " + environment.Name)
+	deployCommands := make(map[string]*deployCommand) // the servers to deploy to
+	allCommands := make(map[string]*deployCommand)    // all servers, including the ones the filter leaves out
+	caption := "Deploying " + environment.Name
+	if !options.filter.empty() {
+		caption += " (" + options.filter.String() + ")"
+	}
+	deployTask := commandtree.NewRootCommand(caption)
+	var lock *schema.DeployLock
+	if !options.dryRun {
+		lock = newDeployLock(environment)
+	}
+	git := currentGit()
+	if options.rollback != nil {
+		git = options.rollback.Git // the containers are labelled with what was deployed
+	}
 	for _, name := range sortKeys(environment.Resources) {
 		res := environment.Resources[name]
 		cmd := &deployCommand{
@@ -63,9 +142,24 @@ func dogoDeploy(config *schema.Config, environment *schema.Environment, allowDec
 			remoteState: &schema.ServerState{},
 			config:      config,
 			environment: environment,
+			rollback:    options.rollback,
+			lock:        lock,
+			git:         git,
+			readOnly:    options.dryRun,
+		}
+
+		// servers left out by the filter only gather state (read only), so
+		// templates render the same as when deploying the whole environment.
+		allCommands[name] = cmd
+		if options.filter.matches(res) {
+			deployCommands[name] = cmd
+			deployTask.Add(environment.Name+"."+name, cmd)
+		} else {
+			cmd.gatherOnly = true
+			cmd.readOnly = true
+			cmd.lock = nil
+			deployTask.Add(environment.Name+"."+name+" (not deployed, only gathering state)", cmd)
 		}
-		deployCommands[name] = cmd
-		deployTask.Add(environment.Name+"."+name, cmd)
 
 		arr, found := provisioningGroups[res.ProvisioningGroup]
 		if !found {
@@ -78,52 +172,86 @@ func dogoDeploy(config *schema.Config, environment *schema.Environment, allowDec
 		return provisioningGroupIds[i] < provisioningGroupIds[j]
 	})
 
+	// tell the webhooks of the environment
+	notifier := newDeployNotifier(environment, options, deployCommands, git)
+	notifier.started()
+
 	// Run!
-	r := commandtree.NewRunner(deployTask, 10)
+	r := newDeployRunner(deployTask, environment, options.parallel)
+
+	calcHooksCommand := &calculateDeploymentHooksCommand{
+		environment:  environment,
+		config:       config,
+		hookCommands: make(map[*schema.DeploymentHook]*commandtree.RootCommand),
+		reuseConnection: func(res *schema.Resource) schema.ServerConnection {
+			for _, d := range allCommands {
+				if d.res.Name == res.Name {
+					return d.connection
+				}
+			}
+			return nil
+		},
+	}
 
+	for _, cmd := range allCommands {
+		cmd.reuseConnection = calcHooksCommand.reuseConnection
+	}
+
+	done := make(chan bool, 1) // the success of the deploy
 	go func() {
-		calcHooksCommand := &calculateDeploymentHooksCommand{
-			environment:              environment,
-			config:                   config,
-			beforeDeploymentCommands: commandtree.NewRootCommand("before_deployment"),
-			afterDeploymentCommands:  commandtree.NewRootCommand("before_deployment"),
-			reuseConnection: func(res *schema.Resource) schema.ServerConnection {
-				for _, d := range deployCommands {
-					if d.res.Name == res.Name {
-						return d.connection
-					}
-				}
-				return nil
-			},
-		}
 		findUnusedServersCommand := &findUnusedServersCommand{
 			environment:       environment,
-			allowDecommission: allowDecommission,
+			allowDecommission: options.allowDecommission,
 		}
 
 		gatheredOnce := false
 		step := deployStepGatherState
+		var remoteRounds [][]*deployCommand
+		var hookRounds [][]commandtree.CommandNode
 		for {
 			// pre-run steps
 			switch step {
 			case deployStepCalculateCommands:
-				if len(environment.DeploymentHooks) > 0 {
+				if len(environment.DeploymentHooks) > 0 && !options.status {
 					deployTask.Add("Calculate deployment hooks", calcHooksCommand)
 				}
-			case deployStepBeforeDeploymentCommands:
-				for _, cmd := range calcHooksCommand.beforeDeploymentCommands.Children {
-					deployTask.Add("before_deployment: "+cmd.AsCommand().Caption, cmd)
-				}
-			case deployStepAfterDeploymentCommands:
-				for _, cmd := range calcHooksCommand.afterDeploymentCommands.Children {
-					deployTask.Add("after_deployment: "+cmd.AsCommand().Caption, cmd)
+			case deployStepBeforeDeploymentCommands, deployStepAfterDeploymentCommands:
+				// run the hooks with the lowest order first
+				if len(hookRounds) > 0 {
+					prefix := "before_deployment: "
+					if step == deployStepAfterDeploymentCommands {
+						prefix = "after_deployment: "
+					}
+					for _, cmd := range hookRounds[0] {
+						deployTask.Add(prefix+cmd.AsCommand().Caption, cmd)
+					}
+					hookRounds = hookRounds[1:]
 				}
 			case deployStepDecommission:
 				deployTask.Add("Check for unused servers", findUnusedServersCommand)
+			case deployStepRecordDeployment:
+				// record the deployment if anything changed (or it's the first one)
+				d, err := newDeployment(environment, deployCommands, options.rollback)
+				if err != nil {
+					deployTask.Add("Record deployment", commandtree.NewFuncCommand(func(c *commandtree.Command) { c.Err(err) }))
+				} else if anyChanges(deployCommands) || d.Number == 1 {
+					d.Git = git
+					deployTask.Add(fmt.Sprintf("Record deployment #%v", d.Number), commandtree.NewFuncCommand(func(c *commandtree.Command) {
+						if err := saveDeployment(d); err != nil {
+							c.Errf("Could not save deployment record: %v", err)
+						}
+					}))
+					for _, t := range deployCommands {
+						t.deployment = d
+					}
+				}
 			}
 
 			// set state
-			for _, t := range deployCommands {
+			for _, t := range allCommands {
+				if t.gatherOnly && step >= deployStepCalculateCommands {
+					t.step = deployStepDone
+				}
 				if t.step != deployStepDone {
 					t.step = step
 				}
@@ -132,11 +260,19 @@ func dogoDeploy(config *schema.Config, environment *schema.Environment, allowDec
 				} else {
 					t.State = commandtree.CommandStateReady
 				}
+				switch step {
+				case deployStepGatherState:
+					t.Limits = []string{limitProvision, limitManagerPrefix + t.res.Manager.Name}
+				case deployStepRemoteCommands:
+					t.Limits = []string{limitRemote}
+				default:
+					t.Limits = nil
+				}
 			}
 
 			// for gatherState; onlhy gather from current provisioningGroup
 			if step == deployStepGatherState {
-				for _, t := range deployCommands {
+				for _, t := range allCommands {
 					t.State = commandtree.CommandStatePaused
 				}
 				id := provisioningGroupIds[0]
@@ -145,61 +281,105 @@ func dogoDeploy(config *schema.Config, environment *schema.Environment, allowDec
 					t.State = commandtree.CommandStateReady
 				}
 				if gatheredOnce {
-					for _, t := range deployCommands {
+					for _, t := range allCommands {
 						t.stepExpandTemplates(false) // TODO: this will generate erorrs
 					}
 				}
 				gatheredOnce = true
 			}
 
+			// for remoteCommands; only run the current batch of servers (rolling deploys)
+			if step == deployStepRemoteCommands {
+				for _, t := range deployCommands {
+					t.State = commandtree.CommandStatePaused
+				}
+				if len(remoteRounds) > 0 {
+					for _, t := range remoteRounds[0] {
+						t.State = commandtree.CommandStateReady
+					}
+					remoteRounds = remoteRounds[1:]
+				}
+			}
+
 			// do a run.
 			success := r.Run(nil)
-			if !success || step == deployStepDone {
-				for _, t := range deployCommands { // to stop statusprinter
+			if !success || step == deployStepDone || (options.dryRun && step == deployStepCalculateCommands) {
+				done <- success
+				releaseLocks(deployCommands)
+				for _, t := range allCommands { // to stop statusprinter
 					t.State = commandtree.CommandStateCompleted
 				}
 				break
 			}
 
-			// go again if we're still gathering state
+			// go again if we're still gathering state, or have more batches of servers or hooks to run
 			if step == deployStepGatherState && len(provisioningGroupIds) > 0 {
 				continue
 			}
+			if step == deployStepRemoteCommands && len(remoteRounds) > 0 {
+				continue
+			}
+			if (step == deployStepBeforeDeploymentCommands || step == deployStepAfterDeploymentCommands) && len(hookRounds) > 0 {
+				continue
+			}
 
 			// move forward a step
 			step = step + 1
+			switch step {
+			case deployStepBeforeDeploymentCommands:
+				hookRounds = calcHooksCommand.rounds(true, changedModules(deployCommands, false))
+			case deployStepRemoteCommands:
+				remoteRounds = rollingRounds(config, environment, deployCommands)
+			case deployStepAfterDeploymentCommands:
+				hookRounds = calcHooksCommand.rounds(false, changedModules(deployCommands, true))
+			}
 		}
 
 		// cleanup after ourselves
-		closeConnections(deployCommands)
+		closeConnections(allCommands)
 	}()
 
 	// start a console monitor
-	commandtree.ConsoleUI(deployTask)
+	runUI(deployTask)
+	notifier.finished(<-done, deployTask, deployCommands)
+
+	result := deployResult{failed: anyFailed(deployCommands) || anyErrorInTree(calcHooksCommand)}
+	switch {
+	case options.status && flagOutput == outputJSON:
+		result.changes = printStatusJSON(environment, deployCommands)
+	case options.status:
+		result.changes = printStatus(environment, deployCommands)
+	case flagOutput == outputJSON:
+		result.changes = printDeployResultJSON(environment, deployCommands, options.dryRun)
+	case options.dryRun:
+		result.changes = printDeployPlan(environment, deployCommands, calcHooksCommand)
+	default:
+		result.changes = anyChanges(deployCommands)
+	}
+	return result
 }
 
 type calculateDeploymentHooksCommand struct {
 	commandtree.Command
-	config                   *schema.Config
-	environment              *schema.Environment
-	reuseConnection          func(res *schema.Resource) schema.ServerConnection
-	beforeDeploymentCommands *commandtree.RootCommand
-	afterDeploymentCommands  *commandtree.RootCommand
+	config          *schema.Config
+	environment     *schema.Environment
+	reuseConnection func(res *schema.Resource) schema.ServerConnection
+	hookCommands    map[*schema.DeploymentHook]*commandtree.RootCommand // before/after_deployment hook => its commands
 }
 
 func (c *calculateDeploymentHooksCommand) Execute() {
 	for _, h := range c.environment.DeploymentHooks {
-		var parent *commandtree.RootCommand
-		if h.RunBeforeDeployment {
-			parent = c.beforeDeploymentCommands
-		} else {
-			parent = c.afterDeploymentCommands
+		if !h.RunBeforeDeployment && !h.RunAfterDeployment {
+			continue // before/after_server hooks are built by each server
 		}
-		err := buildPackageCommands(parent, c.config, c.environment, h.CommandName, h.Command, h.CommandPackage, "", c.reuseConnection, make([]string, 0))
 
+		root := commandtree.NewRootCommand(h.CommandName)
+		err := buildPackageCommands(root, c.config, c.environment, h.CommandName, h.Command, h.CommandPackage, "", c.reuseConnection, make([]string, 0))
 		if err != nil {
 			c.Err(err)
+			continue
 		}
+		c.hookCommands[h] = root
 	}
 }
 
@@ -213,16 +393,36 @@ func closeConnections(commands map[string]*deployCommand) {
 
 type deployCommand struct {
 	commandtree.Command
-	name           string
-	res            *schema.Resource
-	connection     schema.ServerConnection
-	step           deployStep
-	remoteState    *schema.ServerState
-	config         *schema.Config
-	environment    *schema.Environment
-	localCommands  *commandtree.RootCommand
-	remoteCommands *commandtree.RootCommand
-	requireSudo    bool
+	name            string
+	res             *schema.Resource
+	connection      schema.ServerConnection
+	step            deployStep
+	remoteState     *schema.ServerState
+	config          *schema.Config
+	environment     *schema.Environment
+	localCommands   *commandtree.RootCommand
+	remoteCommands  *commandtree.RootCommand
+	moduleCommands  map[string]*moduleCommands // modulename => commands calculated by module
+	requireSudo     bool
+	record          *schema.DeploymentRecord                           // what the modules will deploy to this server
+	rollback        *deployment                                        // if set, deploy this instead of the configuration
+	deployment      *deployment                                        // the record to save on the server after deploying
+	rolling         *schema.Rolling                                    // rolling deploy settings, if deployed in batches
+	rollingBatch    string                                             // description of the batch the server is deployed in
+	lock            *schema.DeployLock                                 // the lock to take on the server while deploying. nil for dry runs
+	locked          bool                                               // true if the lock was taken
+	git             *schema.GitInfo                                    // the git state of the project being deployed
+	lastDeployment  int                                                // the number of the newest deployment record on the server
+	readOnly        bool                                               // nothing is provisioned or changed on the server (dry runs)
+	gatherOnly      bool                                               // left out of the deploy by the filter: only state is gathered
+	reuseConnection func(res *schema.Resource) schema.ServerConnection // the connections to the other servers, for before/after_server hooks
+	pending         []string                                           // what a read only deploy would have to change before commands can be calculated
+}
+
+// moduleCommands are the top level commands a single module calculated for a server.
+type moduleCommands struct {
+	local  []commandtree.CommandNode
+	remote []commandtree.CommandNode
 }
 
 func (c *deployCommand) Execute() {
@@ -237,13 +437,19 @@ func (c *deployCommand) Execute() {
 		c.stepLocalCommands()
 	case deployStepRemoteCommands:
 		c.stepRemoteCommands()
+	case deployStepRecordDeployment:
+		c.stepRecordDeployment()
 	}
 	c.State = commandtree.CommandStatePaused
 }
 
 func (c *deployCommand) stepGatherState() {
-	// 1. Provision (if needed)
-	if c.res.Manager.Provision != nil {
+	// 1. Provision (if needed). Read only deploys only look up what's already provisioned.
+	if c.res.Manager.Provision != nil && c.readOnly {
+		if !c.lookup() {
+			return
+		}
+	} else if c.res.Manager.Provision != nil {
 		c.Logf("Provisioning %v (%v)", c.name, c.res.Manager.Name)
 		err := c.res.Manager.Provision(c.res.ManagerGroup, c.res.Resource, c)
 		if err != nil {
@@ -267,12 +473,28 @@ func (c *deployCommand) stepGatherState() {
 		}
 		c.connection = connection
 
-		// 3. Get state
-		success := false
-		c.remoteState, c.requireSudo, success = getState(c.res, c.connection, c.requireSudo, c, c)
+		// 3. Lock the environment, so no one else deploys it at the same time. This
+		// is done first, so the agent isn't replaced while another deploy uses it.
+		if c.lock != nil && !c.locked && !c.acquireLock() {
+			return
+		}
+
+		// 4. Get state
+		success, upgradeAgent := false, false
+		c.remoteState, c.requireSudo, upgradeAgent, success = readState(c.res, c.connection, c.requireSudo, c.readOnly, c, c)
 		if !success {
 			return
 		}
+		if upgradeAgent {
+			c.pending = append(c.pending, fmt.Sprintf("would upgrade dogoagent to version %v", version.Version))
+			c.step = deployStepDone
+			return
+		}
+
+		// 5. Find the newest deployment record, so the deployment is numbered after it
+		if !c.readLastDeployment() {
+			return
+		}
 
 		// wait for others
 		c.Logf("Waiting for state to be gathered from other servers")
@@ -282,6 +504,30 @@ func (c *deployCommand) stepGatherState() {
 	}
 }
 
+// lookup finds the resource without provisioning it, for read only deploys.
+// Returns false if it isn't provisioned, or can't be found without provisioning it.
+func (c *deployCommand) lookup() bool {
+	if c.res.Manager.Lookup == nil {
+		c.Logf("%v resources can't be checked without provisioning them", c.res.Manager.Name)
+		c.pending = append(c.pending, fmt.Sprintf("would provision %v (%v)", c.name, c.res.Manager.Name))
+		c.step = deployStepDone
+		return false
+	}
+
+	c.Logf("Looking up %v (%v)", c.name, c.res.Manager.Name)
+	found, err := c.res.Manager.Lookup(c.res.ManagerGroup, c.res.Resource, c)
+	if err != nil {
+		c.Errf("Could not look up %v (%v): %v", c.name, c.res.Manager.Name, err)
+		return false
+	}
+	if !found {
+		c.pending = append(c.pending, fmt.Sprintf("would provision %v (%v)", c.name, c.res.Manager.Name))
+		c.step = deployStepDone
+		return false
+	}
+	return true
+}
+
 func (c *deployCommand) stepExpandTemplates(logErrors bool) {
 	for _, err := range expandResourceTemplates(c.res, c.config) {
 		if logErrors {
@@ -298,12 +544,25 @@ func (c *deployCommand) stepCalculateCommands() {
 	// create reusable args object
 	c.remoteCommands = commandtree.NewRootCommand("Remote Commands")
 	c.localCommands = commandtree.NewRootCommand("Local Commands")
+	c.moduleCommands = make(map[string]*moduleCommands)
+	c.record = &schema.DeploymentRecord{}
 	args := schema.CalculateCommandsArgs{
 		LocalCommands:    c.localCommands,
 		RemoteCommands:   c.remoteCommands,
 		RemoteConnection: c.connection,
 		Environment:      c.environment,
 		Config:           c.config,
+		Record:           c.record,
+		Git:              c.git,
+	}
+	if c.rollback != nil {
+		record, found := c.rollback.Servers[c.name]
+		if !found {
+			c.Errf("%v was not part of deployment #%v, so there is nothing to roll back to.", c.name, c.rollback.Number)
+			return
+		}
+		c.Logf("Rolling back to deployment #%v (%v)", c.rollback.Number, c.rollback.Time.Format(time.RFC1123))
+		args.Rollback = record
 	}
 
 	// calculate changes for each module
@@ -329,11 +588,21 @@ func (c *deployCommand) stepCalculateCommands() {
 
 		args.State = c.remoteState.Modules[m.Name]
 		args.Modules = c.res.Modules[m.Name]
+		localBefore := len(c.localCommands.Children)
+		remoteBefore := len(c.remoteCommands.Children)
 		err := m.CalculateCommands(&args)
 		if err != nil {
 			args.Err(err)
 			anyError = true
 		}
+
+		// remember which commands came from this module
+		if len(c.localCommands.Children) > localBefore || len(c.remoteCommands.Children) > remoteBefore {
+			c.moduleCommands[m.Name] = &moduleCommands{
+				local:  c.localCommands.Children[localBefore:],
+				remote: c.remoteCommands.Children[remoteBefore:],
+			}
+		}
 	}
 	if anyError {
 		return
@@ -353,6 +622,7 @@ func (c *deployCommand) stepLocalCommands() {
 	if c.localCommands != nil && len(c.localCommands.Children) > 0 {
 		c.Logf("Executing local commands.")
 		for _, child := range c.localCommands.Children {
+			child.AsCommand().Limits = append(child.AsCommand().Limits, limitLocal)
 			c.Add(child.AsCommand().Caption, child)
 		}
 	}
@@ -360,11 +630,20 @@ func (c *deployCommand) stepLocalCommands() {
 
 func (c *deployCommand) stepRemoteCommands() {
 	if len(c.remoteCommands.Children) > 0 {
-		c.Logf("Executing remote commands.")
+		if c.rollingBatch != "" {
+			c.Logf("Executing remote commands (%v).", c.rollingBatch)
+		} else {
+			c.Logf("Executing remote commands.")
+		}
 		var err error
+		if !c.runServerHooks(true) {
+			return
+		}
+
+		remoteStart := len(c.Children)
 		c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
 			return c.connection.ExecutePipeCommand(cmdPrefix+schema.AgentPath+" exec", func(reader io.Reader, errorReader io.Reader, writer io.Writer) error {
-				err := commandtree.StreamCall(c.remoteCommands, c, 5, reader, errorReader, writer, func(s string) { c.Logf(s) })
+				err := commandtree.StreamCall(c.remoteCommands, c, remoteThreads(c.environment), reader, errorReader, writer, func(s string) { c.Logf(s) })
 				return err
 			})
 		})
@@ -373,11 +652,63 @@ func (c *deployCommand) stepRemoteCommands() {
 			c.Errf(err.Error())
 			return
 		}
+
+		// health gate for rolling deploys
+		if c.rolling != nil && c.rolling.Wait() > 0 && !c.AnyError() {
+			c.waitHealthy(c.rolling.Wait())
+		}
+
+		// after_server hooks only run if the server was deployed without errors
+		failed := c.AnyError()
+		for _, child := range c.Children[remoteStart:] {
+			failed = failed || anyErrorInTree(child)
+		}
+		if !failed {
+			c.runServerHooks(false)
+		}
 	}
 	return
 }
 
+// stepRecordDeployment saves the deployment record on the server
+func (c *deployCommand) stepRecordDeployment() {
+	if c.deployment == nil || c.connection == nil {
+		return
+	}
+
+	content, err := json.Marshal(c.deployment)
+	if err != nil {
+		c.Errf("Could not encode deployment record: %v", err)
+		return
+	}
+
+	root := commandtree.NewRootCommand("Save deployment record")
+	root.Add(fmt.Sprintf("Save deployment record #%v", c.deployment.Number), &registry.SaveDeploymentCommand{
+		Environment: c.environment.Name,
+		Number:      c.deployment.Number,
+		Content:     content,
+		Keep:        remoteDeploymentsKept,
+	})
+	c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
+		return c.connection.ExecutePipeCommand(cmdPrefix+schema.AgentPath+" exec", func(reader io.Reader, errorReader io.Reader, writer io.Writer) error {
+			return commandtree.StreamCall(root, c, remoteThreads(c.environment), reader, errorReader, writer, func(s string) { c.Logf(s) })
+		})
+	})
+	if err != nil {
+		c.Errf("Could not save deployment record on server: %v", err)
+	}
+}
+
 func getState(resource *schema.Resource, connection schema.ServerConnection, useSudo bool, owner commandtree.CommandNode, l schema.Logger) (*schema.ServerState, bool, bool) {
+	remoteState, useSudo, _, success := readState(resource, connection, useSudo, false, owner, l)
+	return remoteState, useSudo, success
+}
+
+// readState gets the state of the server with the dogoagent, and uploads the agent
+// first if it's missing or outdated. Returns the state, whether sudo is required,
+// whether the agent must be upgraded and whether it succeeded. In read only mode the
+// agent isn't touched: the agent must be upgraded, and there's no state.
+func readState(resource *schema.Resource, connection schema.ServerConnection, useSudo bool, readOnly bool, owner commandtree.CommandNode, l schema.Logger) (*schema.ServerState, bool, bool, bool) {
 	// build the state query
 	getStateQuery := make(map[string]interface{})
 	for name, manager := range registry.ModuleManagers {
@@ -404,7 +735,7 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 			query, err := manager.CalculateGetStateQuery(args)
 			if err != nil {
 				l.Errf("Error calculating state query for %v: %v", name, err)
-				return nil, useSudo, false
+				return nil, useSudo, false, false
 			}
 
 			if query != nil {
@@ -421,18 +752,22 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 		agentExists = !isCommandNotFound(err)
 		updateAgent = true
 	} else if remoteState.Version != version.Version {
-		l.Logf(" - server running outdated dogoagent version %v. Current version is %v. Will update.", remoteState.Version, version.Version)
+		l.Logf(" - server running outdated dogoagent version %v. Current version is %v.", remoteState.Version, version.Version)
 		updateAgent = true
 	} else if remoteState.OS != "darwin" && remoteState.UID != 0 {
 		useSudo = true
 		serverState, _, err := executeGetState(useSudo, getStateQuery, "Get state from existing agent", connection, owner)
 		if err != nil {
 			l.Err(err)
-			return nil, useSudo, false
+			return nil, useSudo, false, false
 		}
 		remoteState = serverState
 	}
 
+	if updateAgent && readOnly {
+		l.Logf(" - would upgrade dogoagent to version %v", version.Version)
+		return nil, useSudo, true, true
+	}
 	if updateAgent {
 		l.Logf("Uploading new agent")
 
@@ -470,7 +805,7 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 			})
 			if err != nil {
 				l.Errf("Error deleting %v: %v. Giving Up!", schema.AgentPath, err)
-				return nil, useSudo, false
+				return nil, useSudo, false, false
 			}
 		}
 
@@ -481,12 +816,12 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 			panic(err)
 		}
 		useSudo, err = sudoRetry(useSudo, func(sudo bool, cmdPrefix string) error {
-			return connection.WriteFile(schema.AgentPath, 755, int64(len(agentBytes)), bytes.NewReader(agentBytes), sudo, l.SetProgress)
+			return connection.WriteFile(schema.AgentPath, 0755, int64(len(agentBytes)), bytes.NewReader(agentBytes), sudo, l.SetProgress)
 		})
 		l.SetProgress(0)
 		if err != nil {
 			l.Errf("Could not upload agent to %v. Err:%v", schema.AgentPath, err.Error())
-			return nil, useSudo, false
+			return nil, useSudo, false, false
 		}
 
 		// refresh the DogoAgent state
@@ -494,10 +829,10 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 		remoteState, useSudo, err = executeGetState(useSudo, getStateQuery, "Get state from newly installed agent.", connection, owner)
 		if err != nil {
 			l.Err(err)
-			return nil, useSudo, false
+			return nil, useSudo, false, false
 		} else if remoteState.Version != version.Version {
 			l.Errf("Got bad version from dogoagent (%v). Expected %v. Apparently updating didn't work. Giving up", remoteState.Version, version.Version)
-			return nil, useSudo, false
+			return nil, useSudo, false, false
 		}
 	}
 
@@ -515,7 +850,7 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 		}
 	}
 	if anyErrors {
-		return nil, useSudo, false
+		return nil, useSudo, false, false
 	}
 
 	// copy special dogo args.
@@ -523,7 +858,7 @@ func getState(resource *schema.Resource, connection schema.ServerConnection, use
 		resource.Data[k] = reflect.ValueOf(v).Interface()
 	}
 
-	return remoteState, useSudo, true
+	return remoteState, useSudo, false, true
 }
 
 func executeGetState(useSudo bool, getStateQuery map[string]interface{}, caption string, connection schema.ServerConnection, owner commandtree.CommandNode) (*schema.ServerState, bool, error) {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/registry"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// fakeConnection is a server without a dogoagent. It records the commands run on it.
+type fakeConnection struct {
+	commands []string
+}
+
+func (f *fakeConnection) Shell(cmd string, stderr, stdout io.Writer, stdin io.Reader, width, height int) error {
+	return errors.New("not supported")
+}
+
+func (f *fakeConnection) ExecutePipeCommand(command string, pipesFunc func(reader io.Reader, errorReader io.Reader, writer io.Writer) error) error {
+	f.commands = append(f.commands, command)
+	return errors.New("bash: " + schema.AgentPath + ": command not found")
+}
+
+func (f *fakeConnection) ExecuteCommand(command string) (string, error) {
+	f.commands = append(f.commands, command)
+	return "", nil
+}
+
+func (f *fakeConnection) WriteFile(path string, mode os.FileMode, contentLength int64, content io.Reader, sudo bool, progress func(float64)) error {
+	f.commands = append(f.commands, "write "+path)
+	return nil
+}
+
+func (f *fakeConnection) StartTunnel(localPort int, remotePort int, remoteHost string, reverse bool) (int, error) {
+	return 0, errors.New("not supported")
+}
+
+func (f *fakeConnection) Close() error {
+	return nil
+}
+
+type fakeServer struct {
+	connection *fakeConnection
+}
+
+func (s *fakeServer) OpenConnection() (schema.ServerConnection, error) {
+	return s.connection, nil
+}
+
+// newTestDeployCommand creates a deploy command for the server, gathering state
+func newTestDeployCommand(manager *schema.ResourceManager, server *fakeServer) *deployCommand {
+	modules := make(map[string]interface{})
+	for _, m := range registry.ModuleManagers {
+		if m.ModulePrototype != nil {
+			modules[m.Name] = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(m.ModulePrototype)), 0, 0).Interface()
+		}
+	}
+	return &deployCommand{
+		name:        "web",
+		res:         &schema.Resource{Name: "web", Manager: manager, Resource: server, Data: make(map[string]interface{}), Modules: modules},
+		step:        deployStepGatherState,
+		remoteState: &schema.ServerState{},
+		environment: &schema.Environment{Name: "prod"},
+	}
+}
+
+func TestDryRunGatherState(t *testing.T) {
+	provisioned := false
+	manager := &schema.ResourceManager{
+		Name: "fake",
+		Provision: func(group interface{}, resource interface{}, l schema.Logger) error {
+			provisioned = true
+			return nil
+		},
+	}
+	newCommand := func(server *fakeServer) *deployCommand {
+		cmd := newTestDeployCommand(manager, server)
+		cmd.readOnly = true
+		return cmd
+	}
+
+	// without a lookup, the server can't be found without provisioning it
+	cmd := newCommand(&fakeServer{})
+	cmd.stepGatherState()
+	if provisioned {
+		t.Errorf("expected a dry run to never provision")
+	}
+	if len(cmd.pending) != 1 || !strings.Contains(cmd.pending[0], "would provision") || cmd.step != deployStepDone {
+		t.Errorf("expected the server to be reported as would provision, got %v", cmd.pending)
+	}
+
+	// servers that aren't provisioned
+	manager.Lookup = func(group interface{}, resource interface{}, l schema.Logger) (bool, error) { return false, nil }
+	cmd = newCommand(&fakeServer{})
+	cmd.stepGatherState()
+	if provisioned || len(cmd.pending) != 1 || !strings.Contains(cmd.pending[0], "would provision") {
+		t.Errorf("expected the server to be reported as would provision, got %v", cmd.pending)
+	}
+
+	// servers without the agent
+	manager.Lookup = func(group interface{}, resource interface{}, l schema.Logger) (bool, error) { return true, nil }
+	server := &fakeServer{connection: &fakeConnection{}}
+	cmd = newCommand(server)
+	cmd.stepGatherState()
+	if provisioned {
+		t.Errorf("expected a dry run to never provision")
+	}
+	if len(cmd.pending) != 1 || !strings.Contains(cmd.pending[0], "would upgrade dogoagent") || cmd.step != deployStepDone {
+		t.Errorf("expected the agent to be reported as would upgrade, got %v", cmd.pending)
+	}
+	for _, command := range server.connection.commands {
+		if !strings.HasSuffix(command, schema.AgentPath+" exec") {
+			t.Errorf("expected only the agent to be run, got %v", command)
+		}
+	}
+}
+
+func TestLockBeforeAgent(t *testing.T) {
+	manager := &schema.ResourceManager{Name: "fake"}
+	server := &fakeServer{connection: &fakeConnection{}}
+	cmd := newTestDeployCommand(manager, server)
+	cmd.lock = &schema.DeployLock{ID: "abc", Environment: "prod"}
+	cmd.stepGatherState()
+
+	// the agent is missing, so it's uploaded after the environment is locked
+	commands := server.connection.commands
+	if !cmd.locked || len(commands) == 0 || !strings.Contains(commands[0], "set -C") {
+		t.Fatalf("expected the environment to be locked first, got %v", commands)
+	}
+	if !strings.Contains(strings.Join(commands, "|"), "write "+schema.AgentPath) {
+		t.Errorf("expected the agent to be uploaded, got %v", commands)
+	}
+}
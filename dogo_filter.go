@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/oliverkofoed/dogo/neaterror"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// deployFilter selects the servers of an environment to deploy to. The servers that
+// are filtered out are left alone, but are still part of the template variables
+// (such as 'resourcesbypackage') so rendered config is the same as for a full deploy.
+type deployFilter struct {
+	only     []string // glob patterns of server names to deploy to. Empty means all
+	exclude  []string // glob patterns of server names not to deploy to
+	packages []string // only deploy to servers with one of these packages. Empty means all
+}
+
+// empty returns true if the filter selects every server
+func (f deployFilter) empty() bool {
+	return len(f.only) == 0 && len(f.exclude) == 0 && len(f.packages) == 0
+}
+
+// matches returns true if the resource should be deployed to
+func (f deployFilter) matches(res *schema.Resource) bool {
+	if len(f.only) > 0 && !matchesAny(f.only, res.Name) {
+		return false
+	}
+	if matchesAny(f.exclude, res.Name) {
+		return false
+	}
+	if len(f.packages) > 0 {
+		found := false
+		for _, p := range f.packages {
+			if res.Packages[p] {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks that the patterns and packages are valid, and that at least
+// one server in the environment is selected.
+func (f deployFilter) validate(config *schema.Config, environment *schema.Environment) error {
+	for _, pattern := range append(append([]string{}, f.only...), f.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid server pattern '%v': %v", pattern, err)
+		}
+	}
+	for _, p := range f.packages {
+		if _, found := config.Packages[p]; !found {
+			return fmt.Errorf("unknown package: %v", p)
+		}
+	}
+
+	for _, res := range environment.Resources {
+		if f.matches(res) {
+			return nil
+		}
+	}
+	return neaterror.New(map[string]interface{}{
+		"servers": strings.Join(sortKeys(environment.Resources), ", "),
+	}, "No servers in %v match the filters (%v)", environment.Name, f)
+}
+
+// String describes the filter, e.g. "--only web_* --package memcached"
+func (f deployFilter) String() string {
+	args := make([]string, 0)
+	for _, flag := range []struct {
+		name   string
+		values []string
+	}{{"only", f.only}, {"exclude", f.exclude}, {"package", f.packages}} {
+		if len(flag.values) > 0 {
+			args = append(args, "--"+flag.name+" "+strings.Join(flag.values, ","))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestDeployFilter(t *testing.T) {
+	config := &schema.Config{Packages: map[string]*schema.Package{"web": {Name: "web"}, "memcached": {Name: "memcached"}}}
+	environment := &schema.Environment{Name: "prod", Resources: map[string]*schema.Resource{
+		"web_1":   {Name: "web_1", Packages: map[string]bool{"web": true}},
+		"web_2":   {Name: "web_2", Packages: map[string]bool{"web": true, "memcached": true}},
+		"cache_1": {Name: "cache_1", Packages: map[string]bool{"memcached": true}},
+	}}
+
+	tests := []struct {
+		filter   deployFilter
+		expected []string
+	}{
+		{deployFilter{}, []string{"cache_1", "web_1", "web_2"}},
+		{deployFilter{only: []string{"web_*"}}, []string{"web_1", "web_2"}},
+		{deployFilter{only: []string{"web_*"}, exclude: []string{"web_2"}}, []string{"web_1"}},
+		{deployFilter{packages: []string{"memcached"}}, []string{"cache_1", "web_2"}},
+		{deployFilter{only: []string{"web_*"}, packages: []string{"memcached"}}, []string{"web_2"}},
+	}
+	for _, test := range tests {
+		matched := make([]string, 0)
+		for _, name := range sortKeys(environment.Resources) {
+			if test.filter.matches(environment.Resources[name]) {
+				matched = append(matched, name)
+			}
+		}
+		if len(matched) != len(test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.filter, test.expected, matched)
+			continue
+		}
+		for i := range matched {
+			if matched[i] != test.expected[i] {
+				t.Errorf("%v: expected %v, got %v", test.filter, test.expected, matched)
+			}
+		}
+		if err := test.filter.validate(config, environment); err != nil {
+			t.Errorf("%v: unexpected error: %v", test.filter, err)
+		}
+	}
+
+	for _, filter := range []deployFilter{
+		{only: []string{"db_*"}},
+		{only: []string{"[web"}},
+		{packages: []string{"unknown"}},
+	} {
+		if err := filter.validate(config, environment); err == nil {
+			t.Errorf("%v: expected an error", filter)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// currentGit returns the state of the git repository of the project, or nil
+// if the project isn't in a git repository (or git isn't installed)
+func currentGit() *schema.GitInfo {
+	commit, err := runGit("rev-parse", "HEAD")
+	if err != nil {
+		return nil
+	}
+	info := &schema.GitInfo{Commit: commit}
+	if branch, err := runGit("rev-parse", "--abbrev-ref", "HEAD"); err == nil && branch != "HEAD" {
+		info.Branch = branch
+	}
+	if status, err := runGit("status", "--porcelain"); err != nil || status != "" {
+		info.Dirty = true
+	}
+	return info
+}
+
+// shortCommit abbreviates the commit hash the way git does
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func runGit(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// checkGitRules returns an error if the environment's require_clean_git or
+// allowed_branches settings don't allow deploying from the given git state
+func checkGitRules(environment *schema.Environment, info *schema.GitInfo) error {
+	if !environment.RequireCleanGit && len(environment.AllowedBranches) == 0 {
+		return nil
+	}
+	if info == nil {
+		return fmt.Errorf("%v can only be deployed from a git repository (see require_clean_git and allowed_branches), but the project isn't in one", environment.Name)
+	}
+	if environment.RequireCleanGit && info.Dirty {
+		return fmt.Errorf("%v can't be deployed with uncommitted changes (require_clean_git = true). Commit or stash them first", environment.Name)
+	}
+	if len(environment.AllowedBranches) > 0 {
+		for _, branch := range environment.AllowedBranches {
+			if branch == info.Branch {
+				return nil
+			}
+		}
+		branch := info.Branch
+		if branch == "" {
+			branch = "(detached HEAD)"
+		}
+		return fmt.Errorf("%v can't be deployed from the branch %v. allowed_branches: %v", environment.Name, branch, strings.Join(environment.AllowedBranches, ", "))
+	}
+	return nil
+}
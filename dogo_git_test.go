@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestCheckGitRules(t *testing.T) {
+	env := &schema.Environment{Name: "prod"}
+	if err := checkGitRules(env, nil); err != nil {
+		t.Errorf("expected environments without rules to deploy from anywhere, got %v", err)
+	}
+
+	env.RequireCleanGit = true
+	env.AllowedBranches = []string{"main", "release"}
+	for _, test := range []struct {
+		info *schema.GitInfo
+		ok   bool
+	}{
+		{nil, false},
+		{&schema.GitInfo{Commit: "abc", Branch: "main"}, true},
+		{&schema.GitInfo{Commit: "abc", Branch: "release"}, true},
+		{&schema.GitInfo{Commit: "abc", Branch: "main", Dirty: true}, false},
+		{&schema.GitInfo{Commit: "abc", Branch: "feature"}, false},
+		{&schema.GitInfo{Commit: "abc"}, false}, // detached HEAD
+	} {
+		if err := checkGitRules(env, test.info); (err == nil) != test.ok {
+			t.Errorf("checkGitRules(%+v): expected ok=%v, got %v", test.info, test.ok, err)
+		}
+	}
+}
+
+func TestCurrentGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "dogogit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	if info := currentGit(); info != nil {
+		t.Fatalf("expected no git info outside a repository, got %+v", info)
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", "main"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "first"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+	info := currentGit()
+	if info == nil || len(info.Commit) != 40 || info.Branch != "main" || info.Dirty {
+		t.Fatalf("expected a clean checkout of main, got %+v", info)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "changed.txt"), []byte("x"), 0644)
+	if info := currentGit(); info == nil || !info.Dirty {
+		t.Errorf("expected uncommitted changes to make it dirty, got %+v", info)
+	}
+}
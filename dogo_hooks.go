@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// sortedHooks returns the hooks accepted by include, sorted by order (and name, for hooks with the same order)
+func sortedHooks(hooks []*schema.DeploymentHook, include func(h *schema.DeploymentHook) bool) []*schema.DeploymentHook {
+	result := make([]*schema.DeploymentHook, 0, len(hooks))
+	for _, h := range hooks {
+		if include(h) {
+			result = append(result, h)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Order != result[j].Order {
+			return result[i].Order < result[j].Order
+		}
+		return result[i].CommandName < result[j].CommandName
+	})
+	return result
+}
+
+// changedModules returns the names of the modules that calculated commands for any of
+// the servers or, if executed is true, the ones with commands that ran to completion.
+func changedModules(deployCommands map[string]*deployCommand, executed bool) map[string]bool {
+	changed := make(map[string]bool)
+	for _, cmd := range deployCommands {
+		modules := cmd.plannedModules()
+		if executed {
+			modules = cmd.executedModules()
+		}
+		for moduleName := range modules {
+			changed[moduleName] = true
+		}
+	}
+	return changed
+}
+
+// plannedModules returns the names of the modules that calculated commands for the server
+func (c *deployCommand) plannedModules() map[string]bool {
+	planned := make(map[string]bool)
+	for moduleName := range c.moduleCommands {
+		planned[moduleName] = true
+	}
+	return planned
+}
+
+// rounds returns the commands of the before_deployment (or after_deployment) hooks
+// that apply, given the modules that changed something. The commands are grouped by
+// the order of their hook, and each group is run after the previous one is done.
+func (c *calculateDeploymentHooksCommand) rounds(before bool, changed map[string]bool) [][]commandtree.CommandNode {
+	hooks := sortedHooks(c.environment.DeploymentHooks, func(h *schema.DeploymentHook) bool {
+		return (before && h.RunBeforeDeployment) || (!before && h.RunAfterDeployment)
+	})
+
+	rounds := make([][]commandtree.CommandNode, 0)
+	lastOrder := 0
+	for _, h := range hooks {
+		root, found := c.hookCommands[h]
+		if !found || !h.Applies(changed) {
+			continue
+		}
+		if len(rounds) == 0 || h.Order != lastOrder {
+			rounds = append(rounds, make([]commandtree.CommandNode, 0))
+			lastOrder = h.Order
+		}
+		for _, cmd := range root.Children {
+			cmd.AsCommand().IgnoreErrors = h.OnFailure == schema.HookOnFailureContinue
+			rounds[len(rounds)-1] = append(rounds[len(rounds)-1], cmd)
+		}
+	}
+	return rounds
+}
+
+// serverHooks returns the before_server (or after_server) hooks that apply to the
+// server, given the modules that changed something on it.
+func (c *deployCommand) serverHooks(before bool, changed map[string]bool) []*schema.DeploymentHook {
+	return sortedHooks(c.environment.DeploymentHooks, func(h *schema.DeploymentHook) bool {
+		if (before && !h.RunBeforeServer) || (!before && !h.RunAfterServer) {
+			return false
+		}
+		return h.Applies(changed)
+	})
+}
+
+// runServerHooks runs the before_server (or after_server) hooks for the server, one
+// at a time. Returns false if a hook with on_failure = "abort" failed. A hook runs on
+// the server if it has the package of the hook's command. Otherwise it runs on the
+// servers that have it (e.g. a load balancer), with the server being deployed as
+// the 'server' template variable.
+func (c *deployCommand) runServerHooks(before bool) bool {
+	kind := "after_server"
+	changed := c.executedModules()
+	if before {
+		kind = "before_server"
+		changed = c.plannedModules()
+	}
+
+	reuseConnection := func(res *schema.Resource) schema.ServerConnection {
+		if res.Name == c.res.Name {
+			return c.connection
+		}
+		if c.reuseConnection != nil {
+			return c.reuseConnection(res)
+		}
+		return nil
+	}
+	for _, h := range c.serverHooks(before, changed) {
+		target := ""
+		if c.res.Packages[h.CommandPackage] {
+			target = c.name
+		}
+		root := commandtree.NewRootCommand(h.CommandName)
+		if err := buildPackageCommands(root, c.config, c.environment, h.CommandName, h.Command, h.CommandPackage, target, reuseConnection, make([]string, 0)); err != nil {
+			c.Err(err)
+			return false
+		}
+
+		// the hooks are run right here, so they're added as completed children
+		for _, node := range root.Children {
+			if p, ok := node.(*packageCommand); ok {
+				p.vars = map[string]interface{}{"server": c.res.Data}
+			}
+			cmd := node.AsCommand()
+			cmd.IgnoreErrors = h.OnFailure == schema.HookOnFailureContinue
+			cmd.State = commandtree.CommandStateRunning
+			c.Add(kind+": "+cmd.Caption, node)
+			node.Execute()
+			cmd.State = commandtree.CommandStateCompleted
+
+			if anyErrorInTree(node) {
+				if !cmd.IgnoreErrors {
+					return false
+				}
+				c.Logf("%v: %v failed, continuing (on_failure = %v)", kind, h.CommandName, h.OnFailure)
+			}
+		}
+	}
+	return true
+}
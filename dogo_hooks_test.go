@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestHookSettingsApplies(t *testing.T) {
+	always := schema.HookSettings{}
+	if !always.Applies(map[string]bool{}) {
+		t.Errorf("expected hooks without when_changed to always apply")
+	}
+
+	docker := schema.HookSettings{WhenChanged: []string{"docker"}}
+	if docker.Applies(map[string]bool{"file": true}) {
+		t.Errorf("expected the hook to not apply when docker didn't change")
+	}
+	if !docker.Applies(map[string]bool{"file": true, "docker": true}) {
+		t.Errorf("expected the hook to apply when docker changed")
+	}
+}
+
+func TestDeploymentHookRounds(t *testing.T) {
+	hook := func(name string, order int, onFailure string, whenChanged ...string) *schema.DeploymentHook {
+		return &schema.DeploymentHook{
+			RunAfterDeployment: true,
+			CommandName:        name,
+			HookSettings:       schema.HookSettings{Order: order, OnFailure: onFailure, WhenChanged: whenChanged},
+		}
+	}
+	migrate := hook("migrate", 1, schema.HookOnFailureAbort)
+	warm := hook("warmcache", 1, schema.HookOnFailureContinue)
+	notify := hook("notify", 2, schema.HookOnFailureContinue)
+	assets := hook("assets", 0, schema.HookOnFailureAbort, "file")
+	before := &schema.DeploymentHook{RunBeforeDeployment: true, CommandName: "backup"}
+
+	c := &calculateDeploymentHooksCommand{
+		environment:  &schema.Environment{DeploymentHooks: []*schema.DeploymentHook{notify, warm, migrate, assets, before}},
+		hookCommands: make(map[*schema.DeploymentHook]*commandtree.RootCommand),
+	}
+	for _, h := range c.environment.DeploymentHooks {
+		root := commandtree.NewRootCommand(h.CommandName)
+		root.Add(h.CommandName, commandtree.NewFuncCommand(func(c *commandtree.Command) {}))
+		c.hookCommands[h] = root
+	}
+
+	rounds := c.rounds(false, map[string]bool{"docker": true})
+	captions := make([][]string, 0)
+	for _, round := range rounds {
+		names := make([]string, 0)
+		for _, cmd := range round {
+			names = append(names, cmd.AsCommand().Caption)
+		}
+		captions = append(captions, names)
+	}
+	if len(captions) != 2 || len(captions[0]) != 2 || captions[0][0] != "migrate" || captions[0][1] != "warmcache" || len(captions[1]) != 1 || captions[1][0] != "notify" {
+		t.Fatalf("expected rounds [[migrate warmcache] [notify]], got %v", captions)
+	}
+	if rounds[0][0].AsCommand().IgnoreErrors || !rounds[0][1].AsCommand().IgnoreErrors {
+		t.Errorf("expected only the errors of on_failure = continue hooks to be ignored")
+	}
+
+	if rounds := c.rounds(false, map[string]bool{"file": true}); len(rounds) != 3 || rounds[0][0].AsCommand().Caption != "assets" {
+		t.Errorf("expected the assets hook to run first when files changed, got %v rounds", len(rounds))
+	}
+	if rounds := c.rounds(true, map[string]bool{}); len(rounds) != 1 || rounds[0][0].AsCommand().Caption != "backup" {
+		t.Errorf("expected only the backup hook before the deployment")
+	}
+}
+
+func TestServerHooks(t *testing.T) {
+	drain := &schema.DeploymentHook{RunBeforeServer: true, CommandPackage: "web", CommandName: "drain", HookSettings: schema.HookSettings{Order: 2}}
+	stop := &schema.DeploymentHook{RunBeforeServer: true, CommandPackage: "web", CommandName: "stopjobs", HookSettings: schema.HookSettings{Order: 1}}
+	restart := &schema.DeploymentHook{RunAfterServer: true, CommandPackage: "web", CommandName: "restart", HookSettings: schema.HookSettings{WhenChanged: []string{"file"}}}
+	db := &schema.DeploymentHook{RunBeforeServer: true, CommandPackage: "db", CommandName: "checkpoint"}
+
+	cmd := &deployCommand{
+		environment:    &schema.Environment{DeploymentHooks: []*schema.DeploymentHook{drain, stop, restart, db}},
+		res:            &schema.Resource{Packages: map[string]bool{"web": true}},
+		moduleCommands: map[string]*moduleCommands{"docker": {}},
+	}
+
+	// hooks of packages the server doesn't have run too, on the servers that have them
+	before := cmd.serverHooks(true, cmd.plannedModules())
+	if len(before) != 3 || before[0] != db || before[1] != stop || before[2] != drain {
+		t.Errorf("expected [checkpoint stopjobs drain] before the server, got %v", before)
+	}
+	if after := cmd.serverHooks(false, cmd.plannedModules()); len(after) != 0 {
+		t.Errorf("expected no hooks after the server when no files changed, got %v", after)
+	}
+	cmd.moduleCommands["file"] = &moduleCommands{}
+	if after := cmd.serverHooks(false, cmd.plannedModules()); len(after) != 1 || after[0] != restart {
+		t.Errorf("expected the restart hook after the server, got %v", after)
+	}
+
+	// after_server hooks only apply to changes that were made
+	cmd.moduleCommands["file"] = &moduleCommands{remote: []commandtree.CommandNode{commandtree.NewFuncCommand(func(c *commandtree.Command) {})}}
+	if after := cmd.serverHooks(false, cmd.executedModules()); len(after) != 0 {
+		t.Errorf("expected no hooks after the server when the file change didn't run, got %v", after)
+	}
+}
+
+func TestServerHookVars(t *testing.T) {
+	p := &packageCommand{
+		resource: &schema.Resource{Data: map[string]interface{}{"name": "lb1"}},
+		vars:     map[string]interface{}{"server": map[string]interface{}{"name": "web1"}},
+	}
+	vars := p.getVars(nil)
+	if self, ok := vars["self"].(map[string]interface{}); !ok || self["name"] != "lb1" {
+		t.Errorf("expected self to be the server the hook runs on, got %v", vars["self"])
+	}
+	if server, ok := vars["server"].(map[string]interface{}); !ok || server["name"] != "web1" {
+		t.Errorf("expected server to be the server being deployed, got %v", vars["server"])
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// newDeployLock creates the lock a deploy of the environment takes on each server
+func newDeployLock(environment *schema.Environment) *schema.DeployLock {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	lock := &schema.DeployLock{
+		ID:          hex.EncodeToString(id),
+		Environment: environment.Name,
+		Time:        time.Now().UTC(),
+	}
+	lock.User, lock.Host = currentUserAndHost()
+	return lock
+}
+
+func currentUserAndHost() (string, string) {
+	username, host := "", ""
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		host = h
+	}
+	return username, host
+}
+
+// acquireLock locks the environment on the server. Returns false if the
+// environment is locked by another deploy. The lock is taken with the shell rather
+// than the agent, so it's held before the agent is installed or upgraded.
+func (c *deployCommand) acquireLock() bool {
+	c.Logf("Locking %v", c.environment.Name)
+	content, err := json.Marshal(c.lock)
+	if err != nil {
+		c.Errf("Could not encode lock: %v", err)
+		return false
+	}
+
+	// with noclobber (set -C) the redirect fails if the lock exists, so only one deploy can create it
+	path := environmentLockPath(c.environment.Name)
+	script := "umask 077 && mkdir -p " + shellQuote(schema.LocksPath) + " && set -C && printf '%s' " + shellQuote(string(content)) + " > " + shellQuote(path)
+	c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
+		_, err := c.connection.ExecuteCommand(cmdPrefix + "sh -c " + shellQuote(script))
+		return err
+	})
+	if err != nil {
+		existing, readErr := c.readLock()
+		if readErr != nil {
+			c.Errf("Could not lock %v: %v", c.environment.Name, err)
+			return false
+		}
+		if existing.ID != c.lock.ID {
+			c.Errf("%v is being deployed by %v. Use 'dogo unlock %v --force' if that deploy is no longer running", c.environment.Name, existing.Holder(), c.environment.Name)
+			return false
+		}
+	}
+
+	c.locked = true
+	return true
+}
+
+// readLock reads the lock of the environment on the server
+func (c *deployCommand) readLock() (*schema.DeployLock, error) {
+	var content string
+	var err error
+	c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
+		content, err = c.connection.ExecuteCommand(cmdPrefix + "cat " + shellQuote(environmentLockPath(c.environment.Name)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	lock := &schema.DeployLock{}
+	return lock, json.Unmarshal([]byte(content), lock)
+}
+
+// releaseLocks releases the locks taken by the deploy on all servers
+func releaseLocks(deployCommands map[string]*deployCommand) {
+	var wg sync.WaitGroup
+	for _, cmd := range deployCommands {
+		if !cmd.locked || cmd.connection == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(c *deployCommand) {
+			defer wg.Done()
+			existing, err := c.readLock()
+			if err != nil {
+				c.Errf("Could not release the lock on %v: %v", c.environment.Name, err)
+				return
+			}
+			if existing.ID != c.lock.ID {
+				c.Logf("Not releasing lock, it's held by %v", existing.Holder())
+				c.locked = false
+				return
+			}
+
+			c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
+				_, err := c.connection.ExecuteCommand(cmdPrefix + "rm -f " + shellQuote(environmentLockPath(c.environment.Name)))
+				return err
+			})
+			if err != nil {
+				c.Errf("Could not release the lock on %v: %v", c.environment.Name, err)
+				return
+			}
+			c.locked = false
+		}(cmd)
+	}
+	wg.Wait()
+}
+
+// environmentLockPath is where the lock of the environment is stored on servers
+func environmentLockPath(environment string) string {
+	return schema.LocksPath + "/" + environment + ".json"
+}
+
+// dogoUnlock removes the deploy locks of the environment from all servers. Unless
+// force is true, only locks taken by the current user on this machine are removed.
+func dogoUnlock(config *schema.Config, environment *schema.Environment, force bool) error {
+	setTemplateGlobals(config, environment)
+	lockPath := environmentLockPath(environment.Name)
+	username, host := currentUserAndHost()
+
+	root := commandtree.NewRootCommand("Unlocking " + environment.Name)
+	for _, name := range sortKeys(environment.Resources) {
+		name := name
+		res := environment.Resources[name]
+		server, ok := res.Resource.(schema.ServerResource)
+		if !ok {
+			continue
+		}
+
+		root.Add(environment.Name+"."+name, commandtree.NewFuncCommand(func(c *commandtree.Command) {
+			// servers that aren't provisioned have no locks. Unlocking never provisions.
+			if !isProvisioned(name, res, c) {
+				return
+			}
+
+			connection, err := server.OpenConnection()
+			if err != nil {
+				c.Errf("Could not connect to %v: %v", name, err)
+				return
+			}
+			defer connection.Close()
+
+			_, err = sudoRetry(false, func(sudo bool, cmdPrefix string) error {
+				content, err := connection.ExecuteCommand(cmdPrefix + "cat " + shellQuote(lockPath))
+				if err != nil {
+					if strings.Contains(strings.ToLower(err.Error()), "no such file or directory") {
+						c.Logf("Not locked")
+						return nil
+					}
+					return err
+				}
+
+				holder := "unknown (the lock could not be read)"
+				lock := &schema.DeployLock{}
+				if err := json.Unmarshal([]byte(content), lock); err == nil {
+					holder = lock.Holder()
+					if !force && (lock.User != username || lock.Host != host) {
+						c.Errf("Locked by %v. Use --force to remove the lock of another user or machine", holder)
+						return nil
+					}
+				} else if !force {
+					c.Errf("The lock %v could not be read (%v). Use --force to remove it", lockPath, err)
+					return nil
+				}
+
+				if _, err := connection.ExecuteCommand(cmdPrefix + "rm -f " + shellQuote(lockPath)); err != nil {
+					return err
+				}
+				c.Logf("Removed lock held by %v", holder)
+				return nil
+			})
+			if err != nil {
+				c.Errf("Could not remove lock from %v: %v", name, err)
+			}
+		}))
+	}
+
+	r := commandtree.NewRunner(root, defaultParallel)
+	go r.Run(nil)
+	if err := runUI(root); err != nil {
+		return fmt.Errorf("Could not unlock all servers in %v", environment.Name)
+	}
+	return nil
+}
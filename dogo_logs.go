@@ -1,31 +1,266 @@
 package main
 
-func dogoLogs() {
-	// =======================================================
-	// This is a placeholder for a future 'dogo logs' command
-	// for reading/searching/tailing log files across a given
-	// set of servers/resources
-	// =======================================================
-
-
-	// Notes--------------------------------------------------
-	// 
-	// logs are hierical
-	// environment.machine.<name>.<name>.<name>...
-	// vagrant2.syslog
-	// vagrant2.docker.memcached // exposed by docker module
-	// vagrant2.docker.postgres // exposed by docker module
-	// vagrant2.firewall // exposed by firewall
-
-	// so you can ask for
-	// docker logs -t <match> <search>
-
-	// where -t means: tail!
-
-	// where <match> can be
-	//* // all logs
-	//*.docker.* // all docker logs from all machiens
-	//memcached // anything that matches memcached
-
-	// where <search> is anything that would go into grep
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/oliverkofoed/dogo/neaterror"
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/oliverkofoed/dogo/term"
+)
+
+// logs are hierarchical: server.<source>...
+//   web_1.syslog
+//   web_1.docker.memcached // exposed by docker module
+//   web_1.docker.postgres  // exposed by docker module
+
+type logSource struct {
+	resource *schema.Resource
+	name     string // e.g. web_1.docker.memcached
+	command  string // shell command writing the log to stdout
+}
+
+var logColors = []string{term.Blue, term.Green, term.Yellow, term.White, term.Bold}
+
+func dogoLogs(config *schema.Config, environment *schema.Environment, query string, search string, follow bool, lines int, ignoreCase bool) error {
+	setTemplateGlobals(config, environment)
+
+	// compile the search
+	var filter *regexp.Regexp
+	if search != "" {
+		if ignoreCase {
+			search = "(?i)" + search
+		}
+		var err error
+		filter, err = regexp.Compile(search)
+		if err != nil {
+			return fmt.Errorf("Invalid search expression '%v': %v", search, err)
+		}
+	}
+
+	// find the logs to read
+	var queryParts []string
+	if query != "" {
+		queryParts = strings.Split(query, ".")
+	}
+	sources := make(map[*schema.Resource][]*logSource)
+	found := 0
+	for _, name := range sortKeys(environment.Resources) {
+		res := environment.Resources[name]
+		for _, source := range getLogSources(res, follow, lines) {
+			if matchLogSource(queryParts, strings.Split(source.name, ".")) {
+				sources[res] = append(sources[res], source)
+				found++
+			}
+		}
+	}
+	if found == 0 {
+		return neaterror.New(map[string]interface{}{
+			"available logs": strings.Join(sortedLogSourceNames(environment), ", "),
+		}, "No logs matched '%v'", query)
+	}
+
+	// read logs from all servers in parallel.
+	printer := &logPrinter{filter: filter, colors: make(map[string]string)}
+	var wg sync.WaitGroup
+	for res, arr := range sources {
+		wg.Add(1)
+		go func(res *schema.Resource, arr []*logSource) {
+			defer wg.Done()
+			l := &schema.PrefixLogger{Output: &schema.ConsoleLogger{}, Prefix: environment.Name + "." + res.Name + ": "}
+
+			// provision if required.
+			if res.Manager.Provision != nil {
+				if err := res.Manager.Provision(res.ManagerGroup, res.Resource, l); err != nil {
+					l.Err(err)
+					return
+				}
+			}
+
+			connection, err := res.Resource.(schema.ServerResource).OpenConnection()
+			if err != nil {
+				l.Err(err)
+				return
+			}
+			defer connection.Close()
+
+			var sourceWg sync.WaitGroup
+			for _, source := range arr {
+				sourceWg.Add(1)
+				go func(source *logSource) {
+					defer sourceWg.Done()
+					if err := readLog(connection, source, printer); err != nil {
+						printer.printError(source.name, err)
+					}
+				}(source)
+			}
+			sourceWg.Wait()
+		}(res, arr)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// getLogSources lists the logs available on the given resource.
+func getLogSources(res *schema.Resource, follow bool, lines int) []*logSource {
+	if _, ok := res.Resource.(schema.ServerResource); !ok {
+		return nil
+	}
+
+	tail := "all"
+	if lines > 0 {
+		tail = fmt.Sprintf("%v", lines)
+	}
+
+	// syslog (or the journal on systems without /var/log/syslog)
+	tailArgs := "-n +1"
+	journalArgs := ""
+	if lines > 0 {
+		tailArgs = "-n " + tail
+		journalArgs = " -n " + tail
+	}
+	if follow {
+		tailArgs += " -F"
+		journalArgs += " -f"
+	}
+	sources := []*logSource{{
+		resource: res,
+		name:     res.Name + ".syslog",
+		command:  "sh -c " + shellQuote("if [ -f /var/log/syslog ]; then tail "+tailArgs+" /var/log/syslog; else journalctl --no-pager"+journalArgs+"; fi"),
+	}}
+
+	// docker containers
+	for _, name := range dockerContainerNames(res) {
+		command := "docker logs --tail " + tail
+		if follow {
+			command += " --follow"
+		}
+		sources = append(sources, &logSource{
+			resource: res,
+			name:     res.Name + ".docker." + name,
+			command:  command + " " + shellQuote(name),
+		})
+	}
+
+	return sources
+}
+
+// matchLogSource checks if the log source matches the query. A query
+// with a single part matches any part of the source name (so "memcached"
+// matches "web_1.docker.memcached"), while longer queries are matched part
+// by part from the start of the source name. Parts can use * wildcards.
+func matchLogSource(query []string, source []string) bool {
+	if len(query) == 0 || (len(query) == 1 && query[0] == "") {
+		return true
+	}
+
+	if len(query) == 1 {
+		for _, s := range source {
+			if ok, _ := path.Match(query[0], s); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(query) > len(source) {
+		return false
+	}
+	for i, q := range query {
+		if ok, _ := path.Match(q, source[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func readLog(connection schema.ServerConnection, source *logSource, printer *logPrinter) error {
+	_, err := sudoRetry(false, func(sudo bool, cmdPrefix string) error {
+		return connection.ExecutePipeCommand(cmdPrefix+source.command, func(reader io.Reader, errorReader io.Reader, writer io.Writer) error {
+			var wg sync.WaitGroup
+			var permissionDenied string
+			gotOutput := false
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				scanLines(errorReader, func(line string) {
+					if isPermissionDenied(errors.New(line)) {
+						permissionDenied = line
+						return
+					}
+					printer.printLine(source.name, line)
+				})
+			}()
+			scanLines(reader, func(line string) {
+				gotOutput = true
+				printer.printLine(source.name, line)
+			})
+			wg.Wait()
+
+			if permissionDenied != "" && !gotOutput {
+				return errors.New(permissionDenied)
+			}
+			return nil
+		})
+	})
+	return err
+}
+
+func scanLines(reader io.Reader, f func(line string)) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		f(scanner.Text())
+	}
+}
+
+type logPrinter struct {
+	sync.Mutex
+	filter *regexp.Regexp
+	colors map[string]string
+}
+
+func (p *logPrinter) color(source string) string {
+	color, found := p.colors[source]
+	if !found {
+		color = logColors[len(p.colors)%len(logColors)]
+		p.colors[source] = color
+	}
+	return color
+}
+
+func (p *logPrinter) printLine(source string, line string) {
+	if p.filter != nil && !p.filter.MatchString(line) {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	fmt.Println(p.color(source) + source + term.Reset + " | " + line)
+}
+
+func (p *logPrinter) printError(source string, err error) {
+	p.Lock()
+	defer p.Unlock()
+	fmt.Println(neaterror.String(source+": ", err, term.IsTerminal))
+}
+
+// sortedLogSourceNames is used for listing available logs in error messages.
+func sortedLogSourceNames(environment *schema.Environment) []string {
+	names := make([]string, 0)
+	for _, res := range environment.Resources {
+		for _, source := range getLogSources(res, false, 0) {
+			names = append(names, source.name)
+		}
+	}
+	sort.Strings(names)
+	return names
 }
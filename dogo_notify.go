@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/oliverkofoed/dogo/term"
+)
+
+// notifyTimeout is how long to wait for a webhook to accept an event
+const notifyTimeout = time.Second * 10
+
+// maxNotifyErrors is the max number of errors included in an event
+const maxNotifyErrors = 10
+
+// notifyEvent is the JSON posted to webhooks with the generic format
+type notifyEvent struct {
+	Event       string    `json:"event"` // start, finish or failure
+	Environment string    `json:"environment"`
+	User        string    `json:"user"`
+	Host        string    `json:"host"`
+	Commit      string    `json:"commit,omitempty"`
+	Branch      string    `json:"branch,omitempty"`
+	Dirty       bool      `json:"dirty,omitempty"`       // true if deployed with uncommitted changes
+	RollbackTo  int       `json:"rollback_to,omitempty"` // the deployment rolled back to
+	Filter      string    `json:"filter,omitempty"`
+	Servers     []string  `json:"servers"`           // the servers being deployed
+	Changed     []string  `json:"changed,omitempty"` // the servers that were changed
+	Failed      []string  `json:"failed,omitempty"`  // the servers with errors
+	Errors      []string  `json:"errors,omitempty"`
+	Duration    float64   `json:"duration"` // seconds since the deploy started
+	Time        time.Time `json:"time"`
+}
+
+// slackMessage is the JSON posted to webhooks with the slack format
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// deployNotifier posts the events of a deploy to the webhooks of the environment
+type deployNotifier struct {
+	environment *schema.Environment
+	start       time.Time
+	event       notifyEvent // the fields shared by all events
+}
+
+// newDeployNotifier returns nil if there are no webhooks to notify, or the deploy is a dry run
+func newDeployNotifier(environment *schema.Environment, options deployOptions, deployCommands map[string]*deployCommand, git *schema.GitInfo) *deployNotifier {
+	if len(environment.Notify) == 0 || options.dryRun {
+		return nil
+	}
+
+	n := &deployNotifier{
+		environment: environment,
+		start:       time.Now(),
+		event: notifyEvent{
+			Environment: environment.Name,
+			Servers:     make([]string, 0, len(deployCommands)),
+		},
+	}
+	for _, name := range sortKeys(environment.Resources) {
+		if _, found := deployCommands[name]; found {
+			n.event.Servers = append(n.event.Servers, name)
+		}
+	}
+	n.event.User, n.event.Host = currentUserAndHost()
+	if git != nil {
+		n.event.Commit, n.event.Branch, n.event.Dirty = git.Commit, git.Branch, git.Dirty
+	}
+	if options.rollback != nil {
+		n.event.RollbackTo = options.rollback.Number
+	}
+	if !options.filter.empty() {
+		n.event.Filter = options.filter.String()
+	}
+	return n
+}
+
+// started posts the start event
+func (n *deployNotifier) started() {
+	if n == nil {
+		return
+	}
+	event := n.event
+	event.Event = schema.NotifyEventStart
+	n.post(&event)
+}
+
+// finished posts the finish event, or the failure event if the deploy failed
+func (n *deployNotifier) finished(success bool, root commandtree.CommandNode, deployCommands map[string]*deployCommand) {
+	if n == nil {
+		return
+	}
+
+	event := n.event
+	event.Event = schema.NotifyEventFinish
+	if !success {
+		event.Event = schema.NotifyEventFailure
+	}
+	event.Changed = changedServers(n.environment, deployCommands, false)
+	for _, name := range event.Servers {
+		cmd := deployCommands[name]
+		if anyErrorInTree(cmd) {
+			event.Failed = append(event.Failed, name)
+		}
+	}
+	for _, child := range root.AsCommand().Children {
+		event.Errors = collectErrors(child, "", event.Errors)
+	}
+	if len(event.Errors) > maxNotifyErrors {
+		event.Errors = append(event.Errors[:maxNotifyErrors], fmt.Sprintf("... and %v more", len(event.Errors)-maxNotifyErrors))
+	}
+	event.Duration = time.Since(n.start).Seconds()
+	n.post(&event)
+}
+
+// post sends the event to the webhooks that want it. Webhooks that fail are
+// reported, but don't fail the deploy.
+func (n *deployNotifier) post(event *notifyEvent) {
+	event.Time = time.Now().UTC()
+	for _, notify := range n.environment.Notify {
+		if !notify.Wants(event.Event) {
+			continue
+		}
+		url, err := notify.URL.Render(nil)
+		if err == nil {
+			err = postNotification(url, notify.Format, event)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, term.Yellow+"Could not notify about the "+event.Event+" of the deploy: "+err.Error()+term.Reset)
+		}
+	}
+}
+
+// postNotification posts the event to the url in the given format
+func postNotification(url string, format string, event *notifyEvent) error {
+	var body interface{} = event
+	if format == schema.NotifyFormatSlack {
+		body = &slackMessage{Text: slackText(event)}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// slackText is the message shown in slack for the event
+func slackText(event *notifyEvent) string {
+	what := "Deploy of *" + event.Environment + "*"
+	if event.RollbackTo > 0 {
+		what = fmt.Sprintf("Rollback of *%v* to deployment #%v", event.Environment, event.RollbackTo)
+	}
+	if event.Filter != "" {
+		what += " (" + event.Filter + ")"
+	}
+
+	lines := make([]string, 0)
+	switch event.Event {
+	case schema.NotifyEventStart:
+		lines = append(lines, fmt.Sprintf(":rocket: %v started by %v@%v", what, event.User, event.Host))
+	case schema.NotifyEventFinish:
+		lines = append(lines, fmt.Sprintf(":white_check_mark: %v by %v@%v finished in %v", what, event.User, event.Host, slackDuration(event.Duration)))
+	default:
+		lines = append(lines, fmt.Sprintf(":x: %v by %v@%v failed after %v", what, event.User, event.Host, slackDuration(event.Duration)))
+	}
+	if event.Commit != "" {
+		commit := "Commit: `" + event.Commit + "`"
+		if event.Branch != "" {
+			commit += " on " + event.Branch
+		}
+		if event.Dirty {
+			commit += " (with uncommitted changes)"
+		}
+		lines = append(lines, commit)
+	}
+	if event.Event == schema.NotifyEventStart {
+		lines = append(lines, "Servers: "+strings.Join(event.Servers, ", "))
+	} else if len(event.Changed) > 0 {
+		lines = append(lines, "Changed: "+strings.Join(event.Changed, ", "))
+	} else {
+		lines = append(lines, "No changes")
+	}
+	if len(event.Failed) > 0 {
+		lines = append(lines, "Failed: "+strings.Join(event.Failed, ", "))
+	}
+	if len(event.Errors) > 0 {
+		lines = append(lines, "```"+strings.Join(event.Errors, "\n")+"```")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func slackDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+// collectErrors appends the errors logged in the command tree, prefixed by the captions of their commands
+func collectErrors(node commandtree.CommandNode, prefix string, errors []string) []string {
+	cmd := node.AsCommand()
+	caption := cmd.Caption
+	if prefix != "" {
+		caption = prefix + " > " + caption
+	}
+	for _, entry := range cmd.LogArray {
+		if entry.Error != nil {
+			errors = append(errors, caption+": "+entry.Error.Error())
+		}
+	}
+	for _, child := range cmd.Children {
+		errors = collectErrors(child, caption, errors)
+	}
+	return errors
+}
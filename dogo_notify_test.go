@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestDeployNotifier(t *testing.T) {
+	var mutex sync.Mutex
+	received := make(map[string][]string) // path => bodies
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], string(b))
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	url := func(path string) schema.Template {
+		template, err := newTemplateSource().NewTemplate("test", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return template
+	}
+	environment := &schema.Environment{
+		Name:      "prod",
+		Resources: map[string]*schema.Resource{"web1": {Name: "web1"}, "web2": {Name: "web2"}, "db": {Name: "db"}},
+		Notify: []*schema.Notify{
+			{URL: url("/generic"), Format: schema.NotifyFormatGeneric},
+			{URL: url("/slack"), Format: schema.NotifyFormatSlack, Events: []string{schema.NotifyEventFailure}},
+		},
+	}
+
+	root := commandtree.NewRootCommand("Deploying prod")
+	ran := commandtree.NewFuncCommand(func(c *commandtree.Command) {})
+	notRun := commandtree.NewFuncCommand(func(c *commandtree.Command) {})
+	web1 := &deployCommand{name: "web1", moduleCommands: map[string]*moduleCommands{"docker": {local: []commandtree.CommandNode{ran}}}}
+	web2 := &deployCommand{name: "web2", moduleCommands: map[string]*moduleCommands{"docker": {local: []commandtree.CommandNode{notRun}}}} // calculated, but never run
+	root.Add("prod.web1", web1)
+	root.Add("prod.web2", web2)
+	web1.Add("build web", ran).AsCommand().State = commandtree.CommandStateCompleted
+	deployCommands := map[string]*deployCommand{"web1": web1, "web2": web2}
+
+	if newDeployNotifier(environment, deployOptions{dryRun: true}, deployCommands, nil) != nil {
+		t.Errorf("expected no notifications for dry runs")
+	}
+
+	notifier := newDeployNotifier(environment, deployOptions{}, deployCommands, &schema.GitInfo{Commit: "abc123", Branch: "main"})
+	notifier.started()
+	notifier.finished(true, root, deployCommands)
+
+	if len(received["/slack"]) != 0 {
+		t.Errorf("expected the slack webhook to only get failures, got %v", received["/slack"])
+	}
+	if len(received["/generic"]) != 2 {
+		t.Fatalf("expected the generic webhook to get the start and finish, got %v", received["/generic"])
+	}
+	start, finish := &notifyEvent{}, &notifyEvent{}
+	if err := json.Unmarshal([]byte(received["/generic"][0]), start); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(received["/generic"][1]), finish); err != nil {
+		t.Fatal(err)
+	}
+	if start.Event != "start" || start.Environment != "prod" || start.Commit != "abc123" || start.Branch != "main" || strings.Join(start.Servers, ",") != "web1,web2" {
+		t.Errorf("unexpected start event: %+v", start)
+	}
+	if finish.Event != "finish" || strings.Join(finish.Changed, ",") != "web1" || len(finish.Failed) != 0 || len(finish.Errors) != 0 {
+		t.Errorf("unexpected finish event: %+v", finish)
+	}
+
+	// failures are posted to both, with the errors
+	web2.Errf("connection refused")
+	notifier.finished(false, root, deployCommands)
+	if len(received["/slack"]) != 1 || len(received["/generic"]) != 3 {
+		t.Fatalf("expected the failure to be posted to both webhooks, got %v", received)
+	}
+	failure := &notifyEvent{}
+	if err := json.Unmarshal([]byte(received["/generic"][2]), failure); err != nil {
+		t.Fatal(err)
+	}
+	if failure.Event != "failure" || strings.Join(failure.Changed, ",") != "web1" || strings.Join(failure.Failed, ",") != "web2" || len(failure.Errors) != 1 || failure.Errors[0] != "prod.web2: connection refused" {
+		t.Errorf("unexpected failure event: %+v", failure)
+	}
+	message := &slackMessage{}
+	if err := json.Unmarshal([]byte(received["/slack"][0]), message); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(message.Text, "Deploy of *prod*") || !strings.Contains(message.Text, "failed") || !strings.Contains(message.Text, "connection refused") {
+		t.Errorf("unexpected slack message: %v", message.Text)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/oliverkofoed/dogo/term"
+)
+
+// anyChanges returns true if any server has local or remote commands calculated.
+func anyChanges(deployCommands map[string]*deployCommand) bool {
+	for _, cmd := range deployCommands {
+		if len(cmd.moduleCommands) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// anyFailed returns true if any server has errors, or its commands could not be calculated
+func anyFailed(deployCommands map[string]*deployCommand) bool {
+	for _, cmd := range deployCommands {
+		if anyErrorInTree(cmd) || (cmd.moduleCommands == nil && len(cmd.pending) == 0 && cmd.step != deployStepDone) {
+			return true
+		}
+	}
+	return false
+}
+
+// executedModules returns the modules whose commands ran to completion on the server,
+// without errors. Remote commands are in the tree of the server as copies, with the
+// ids of the commands that were sent.
+func (c *deployCommand) executedModules() map[string]bool {
+	nodes := make(map[string]commandtree.CommandNode)
+	var index func(node commandtree.CommandNode)
+	index = func(node commandtree.CommandNode) {
+		for _, child := range node.AsCommand().Children {
+			if id := child.AsCommand().ID; id != "" {
+				nodes[id] = child
+			}
+			index(child)
+		}
+	}
+	index(c)
+
+	executed := make(map[string]bool)
+	for moduleName, m := range c.moduleCommands {
+		ran := true
+		for _, list := range [][]commandtree.CommandNode{m.local, m.remote} {
+			for _, cmd := range list {
+				node, found := nodes[cmd.AsCommand().ID]
+				ran = ran && found && completed(node)
+			}
+		}
+		if ran {
+			executed[moduleName] = true
+		}
+	}
+	return executed
+}
+
+// completed returns true if the command and all its children ran without errors
+func completed(node commandtree.CommandNode) bool {
+	cmd := node.AsCommand()
+	if cmd.State != commandtree.CommandStateCompleted || cmd.AnyError() {
+		return false
+	}
+	for _, child := range cmd.Children {
+		if !completed(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// changedServers returns the names of the servers that were changed, in the order of
+// the resources of the environment: the ones where commands of a module ran to
+// completion. For dry runs it's the servers that would be changed.
+func changedServers(environment *schema.Environment, deployCommands map[string]*deployCommand, dryRun bool) []string {
+	changed := make([]string, 0)
+	for _, name := range sortKeys(environment.Resources) {
+		cmd, found := deployCommands[name]
+		if !found {
+			continue
+		}
+		if (dryRun && (len(cmd.moduleCommands) > 0 || len(cmd.pending) > 0)) || (!dryRun && len(cmd.executedModules()) > 0) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// printDeployPlan prints the commands each module calculated for each server
+// (and the deployment hooks that would run). Returns true if any changes are pending.
+func printDeployPlan(environment *schema.Environment, deployCommands map[string]*deployCommand, hooks *calculateDeploymentHooksCommand) bool {
+	fmt.Println()
+	fmt.Println(term.Bold + "Deployment plan for " + environment.Name + " (dry run, nothing was changed)" + term.Reset)
+
+	pending := false
+	for _, name := range sortKeys(environment.Resources) {
+		cmd, found := deployCommands[name]
+		if !found {
+			continue
+		}
+
+		fmt.Println(term.Bold + environment.Name + "." + name + term.Reset)
+		if len(cmd.pending) > 0 {
+			pending = true
+			for _, p := range cmd.pending {
+				fmt.Println("  " + term.Yellow + "+ " + term.Reset + p)
+			}
+			continue
+		}
+		if cmd.moduleCommands == nil {
+			if cmd.step == deployStepDone {
+				fmt.Println("  not a server, nothing to deploy")
+			} else {
+				fmt.Println(term.Red + "  could not calculate commands" + term.Reset)
+			}
+			continue
+		}
+		if len(cmd.moduleCommands) == 0 {
+			fmt.Println(term.Green + "  no changes" + term.Reset)
+			continue
+		}
+
+		pending = true
+		moduleNames := make([]string, 0, len(cmd.moduleCommands))
+		for moduleName := range cmd.moduleCommands {
+			moduleNames = append(moduleNames, moduleName)
+		}
+		sort.Strings(moduleNames)
+		for _, moduleName := range moduleNames {
+			m := cmd.moduleCommands[moduleName]
+			fmt.Println("  " + moduleName)
+			for _, c := range m.local {
+				printPlanCommand(c, "    ", "(local) ")
+			}
+			for _, c := range m.remote {
+				printPlanCommand(c, "    ", "")
+			}
+		}
+		if len(cmd.remoteCommands.Children) > 0 {
+			for _, h := range cmd.serverHooks(true, cmd.plannedModules()) {
+				fmt.Println("  " + term.Yellow + "+ " + term.Reset + "before_server: " + h.CommandName)
+			}
+			for _, h := range cmd.serverHooks(false, cmd.plannedModules()) {
+				fmt.Println("  " + term.Yellow + "+ " + term.Reset + "after_server: " + h.CommandName)
+			}
+		}
+	}
+
+	if hooks != nil {
+		changed := changedModules(deployCommands, false)
+		for _, before := range []bool{true, false} {
+			rounds := hooks.rounds(before, changed)
+			if len(rounds) == 0 {
+				continue
+			}
+			if before {
+				fmt.Println(term.Bold + "before_deployment" + term.Reset)
+			} else {
+				fmt.Println(term.Bold + "after_deployment" + term.Reset)
+			}
+			for _, round := range rounds {
+				for _, c := range round {
+					printPlanCommand(c, "  ", "")
+				}
+			}
+		}
+	}
+
+	return pending
+}
+
+func printPlanCommand(node commandtree.CommandNode, indent string, prefix string) {
+	cmd := node.AsCommand()
+	fmt.Println(indent + term.Yellow + "+ " + term.Reset + prefix + cmd.Caption)
+	if d, ok := node.(commandtree.Describer); ok {
+		for _, line := range d.Describe() {
+			fmt.Println(indent + "    " + line)
+		}
+	}
+	for _, child := range cmd.Children {
+		printPlanCommand(child, indent+"  ", prefix)
+	}
+}
+
+// deployResultJSON is the final line written by deploys with --output=json
+type deployResultJSON struct {
+	Event       string                                   `json:"event"`
+	Environment string                                   `json:"environment"`
+	DryRun      bool                                     `json:"dry_run"`
+	Changed     []string                                 `json:"changed"`
+	Failed      []string                                 `json:"failed"`
+	Plan        map[string]map[string][]*planCommandJSON `json:"plan,omitempty"` // server => module => commands
+}
+
+type planCommandJSON struct {
+	Caption  string             `json:"caption"`
+	Local    bool               `json:"local,omitempty"`
+	Details  []string           `json:"details,omitempty"`
+	Children []*planCommandJSON `json:"children,omitempty"`
+}
+
+// printDeployResultJSON writes which servers changed (or would change, for
+// dry runs) and which failed as a single JSON line. Returns true if any servers changed.
+func printDeployResultJSON(environment *schema.Environment, deployCommands map[string]*deployCommand, dryRun bool) bool {
+	result := &deployResultJSON{
+		Event:       "deploy",
+		Environment: environment.Name,
+		DryRun:      dryRun,
+		Changed:     changedServers(environment, deployCommands, dryRun),
+		Failed:      make([]string, 0),
+	}
+	if dryRun {
+		result.Plan = make(map[string]map[string][]*planCommandJSON)
+	}
+
+	for _, name := range sortKeys(environment.Resources) {
+		cmd, found := deployCommands[name]
+		if !found {
+			continue
+		}
+		if anyErrorInTree(cmd) {
+			result.Failed = append(result.Failed, name)
+		}
+		if !dryRun {
+			continue
+		}
+		if len(cmd.pending) > 0 {
+			plan := make([]*planCommandJSON, 0, len(cmd.pending))
+			for _, p := range cmd.pending {
+				plan = append(plan, &planCommandJSON{Caption: p})
+			}
+			result.Plan[name] = map[string][]*planCommandJSON{"dogo": plan}
+			continue
+		}
+		if len(cmd.moduleCommands) == 0 {
+			continue
+		}
+
+		modules := make(map[string][]*planCommandJSON)
+		for moduleName, m := range cmd.moduleCommands {
+			for _, c := range m.local {
+				modules[moduleName] = append(modules[moduleName], planCommandToJSON(c, true))
+			}
+			for _, c := range m.remote {
+				modules[moduleName] = append(modules[moduleName], planCommandToJSON(c, false))
+			}
+		}
+		result.Plan[name] = modules
+	}
+
+	json.NewEncoder(os.Stdout).Encode(result)
+
+	return len(result.Changed) > 0
+}
+
+func planCommandToJSON(node commandtree.CommandNode, local bool) *planCommandJSON {
+	cmd := node.AsCommand()
+	p := &planCommandJSON{Caption: cmd.Caption, Local: local}
+	if d, ok := node.(commandtree.Describer); ok {
+		p.Details = d.Describe()
+	}
+	for _, child := range cmd.Children {
+		p.Children = append(p.Children, planCommandToJSON(child, local))
+	}
+	return p
+}
+
+// anyErrorInTree returns true if the command or any of its children logged an error.
+func anyErrorInTree(node commandtree.CommandNode) bool {
+	cmd := node.AsCommand()
+	if cmd.AnyError() {
+		return true
+	}
+	for _, child := range cmd.Children {
+		if anyErrorInTree(child) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestExecutedModules(t *testing.T) {
+	noop := func(c *commandtree.Command) {}
+	remote := commandtree.NewRootCommand("Remote Commands")
+	container := remote.Add("start web", commandtree.NewFuncCommand(noop))
+	file := remote.Add("write /etc/app.conf", commandtree.NewFuncCommand(noop))
+	firewall := remote.Add("sync firewall", commandtree.NewFuncCommand(noop))
+	image := commandtree.NewFuncCommand(noop)
+
+	cmd := &deployCommand{moduleCommands: map[string]*moduleCommands{
+		"docker":   {local: []commandtree.CommandNode{image}, remote: []commandtree.CommandNode{container}},
+		"file":     {remote: []commandtree.CommandNode{file}},
+		"firewall": {remote: []commandtree.CommandNode{firewall}},
+	}}
+
+	// the local command ran, and the remote commands were copied into the tree as they ran
+	cmd.Add("send image", image).AsCommand().State = commandtree.CommandStateCompleted
+	copied := func(sent commandtree.CommandNode, state commandtree.CommandState) *commandtree.Command {
+		c := commandtree.NewFuncCommand(noop)
+		c.AsCommand().ID, c.AsCommand().State = sent.AsCommand().ID, state
+		cmd.Children = append(cmd.Children, c)
+		return c.AsCommand()
+	}
+	copied(container, commandtree.CommandStateCompleted)
+	copied(file, commandtree.CommandStateCompleted).Errf("disk full")
+	// firewall never ran: the deploy was aborted
+
+	executed := cmd.executedModules()
+	if len(executed) != 1 || !executed["docker"] {
+		t.Errorf("expected only docker to have run to completion, got %v", executed)
+	}
+
+	environment := &schema.Environment{Resources: map[string]*schema.Resource{"web": {}, "db": {}}}
+	deployCommands := map[string]*deployCommand{
+		"web": cmd,
+		"db":  {moduleCommands: map[string]*moduleCommands{"file": {remote: []commandtree.CommandNode{commandtree.NewFuncCommand(noop)}}}},
+	}
+	if changed := changedServers(environment, deployCommands, false); strings.Join(changed, ",") != "web" {
+		t.Errorf("expected only web to have changed, got %v", changed)
+	}
+	if changed := changedServers(environment, deployCommands, true); strings.Join(changed, ",") != "db,web" {
+		t.Errorf("expected both servers to change in a dry run, got %v", changed)
+	}
+}
+
+func TestAnyFailed(t *testing.T) {
+	inSync := &deployCommand{moduleCommands: map[string]*moduleCommands{}, step: deployStepCalculateCommands}
+	pending := &deployCommand{pending: []string{"would provision web (fake)"}, step: deployStepDone}
+	notServer := &deployCommand{step: deployStepDone}
+	if anyFailed(map[string]*deployCommand{"web": inSync, "db": pending, "dns": notServer}) {
+		t.Errorf("expected no failures")
+	}
+
+	// the connection failed, so the commands were never calculated
+	unreachable := &deployCommand{step: deployStepGatherState}
+	unreachable.Errf("could not connect")
+	if !anyFailed(map[string]*deployCommand{"web": inSync, "db": unreachable}) {
+		t.Errorf("expected a server without calculated commands to fail")
+	}
+
+	if code := (deployResult{changes: true, failed: true}).exitCode(); code != exitCodeCheckFailed {
+		t.Errorf("expected failures to have their own exit code, got %v", code)
+	}
+	if code := (deployResult{changes: true}).exitCode(); code != exitCodeChangesPending {
+		t.Errorf("expected pending changes to exit with %v, got %v", exitCodeChangesPending, code)
+	}
+	if code := (deployResult{}).exitCode(); code != 0 {
+		t.Errorf("expected no changes to exit with 0, got %v", code)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/neaterror"
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/oliverkofoed/dogo/term"
+	"github.com/oliverkofoed/dogo/version"
+)
+
+const deploymentsDir = ".dogocache/deployments/"
+
+// remoteDeploymentsKept is the number of deployment records kept on each server
+const remoteDeploymentsKept = 20
+
+// deployment is the record of a successful deploy to an environment.
+type deployment struct {
+	Number      int                                 `json:"number"`
+	Environment string                              `json:"environment"`
+	Time        time.Time                           `json:"time"`
+	User        string                              `json:"user,omitempty"`
+	Host        string                              `json:"host,omitempty"`
+	Version     string                              `json:"version"`
+	RollbackOf  int                                 `json:"rollback_of,omitempty"` // the deployment that was rolled back to
+	Git         *schema.GitInfo                     `json:"git,omitempty"`         // the git state of the project that was deployed
+	Servers     map[string]*schema.DeploymentRecord `json:"servers"`
+}
+
+// newDeployment builds the record of what the deploy commands deployed
+func newDeployment(environment *schema.Environment, deployCommands map[string]*deployCommand, rollback *deployment) (*deployment, error) {
+	previous, err := loadDeployments(environment.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &deployment{
+		Number:      1,
+		Environment: environment.Name,
+		Time:        time.Now().UTC(),
+		Version:     version.Version,
+		Servers:     make(map[string]*schema.DeploymentRecord),
+	}
+	if len(previous) > 0 {
+		d.Number = previous[len(previous)-1].Number + 1
+	}
+	for _, cmd := range deployCommands { // deploys from other machines are only recorded on the servers
+		if cmd.lastDeployment >= d.Number {
+			d.Number = cmd.lastDeployment + 1
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		d.User = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		d.Host = host
+	}
+	if rollback != nil {
+		d.RollbackOf = rollback.Number
+	}
+	for name, cmd := range deployCommands {
+		if cmd.record != nil {
+			d.Servers[name] = cmd.record
+		}
+	}
+
+	// servers that weren't part of the deploy (see deployFilter) still have what
+	// the previous deployment put on them.
+	if len(previous) > 0 {
+		for name, record := range previous[len(previous)-1].Servers {
+			if _, deployed := deployCommands[name]; !deployed && environment.Resources[name] != nil {
+				d.Servers[name] = record
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// readLastDeployment finds the number of the newest deployment record stored on the server.
+func (c *deployCommand) readLastDeployment() bool {
+	dir := schema.DeploymentsPath + "/" + c.environment.Name
+	var list string
+	var err error
+	c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
+		list, err = c.connection.ExecuteCommand(cmdPrefix + "ls " + shellQuote(dir))
+		if err != nil && strings.Contains(strings.ToLower(err.Error()), "no such file or directory") {
+			list, err = "", nil // nothing deployed yet
+		}
+		return err
+	})
+	if err != nil {
+		c.Errf("Could not read deployment records in %v: %v", dir, err)
+		return false
+	}
+	c.lastDeployment = lastDeploymentNumber(strings.Fields(list))
+	return true
+}
+
+// lastDeploymentNumber returns the highest number of the (number).json deployment records
+func lastDeploymentNumber(names []string) int {
+	last := 0
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(name, ".json")); err == nil && n > last {
+			last = n
+		}
+	}
+	return last
+}
+
+// loadDeployments reads the deployment records stored locally for the environment, oldest first.
+func loadDeployments(environmentName string) ([]*deployment, error) {
+	dir := filepath.Join(deploymentsDir, environmentName)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	deployments := make([]*deployment, 0, len(files))
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".json")); err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		d := &deployment{}
+		if err := json.Unmarshal(b, d); err != nil {
+			return nil, fmt.Errorf("Could not read deployment record %v: %v", filepath.Join(dir, f.Name()), err)
+		}
+		deployments = append(deployments, d)
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].Number < deployments[j].Number
+	})
+
+	return deployments, nil
+}
+
+// saveDeployment writes the deployment record to .dogocache/deployments/(environment)/(number).json.
+// It fails if the record already exists, since that means another deploy got the same number.
+func saveDeployment(d *deployment) error {
+	dir := filepath.Join(deploymentsDir, d.Environment)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%v.json", d.Number))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("Deployment record %v already exists. Was %v deployed from somewhere else at the same time?", path, d.Environment)
+		}
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// deploymentHistory returns the deployment records for the environment, oldest first.
+// The records stored on the servers are merged into the local ones first, since
+// deploys done from other machines are only recorded there.
+func deploymentHistory(config *schema.Config, environment *schema.Environment) ([]*deployment, error) {
+	if err := fetchRemoteDeployments(config, environment); err != nil {
+		return nil, err
+	}
+	return loadDeployments(environment.Name)
+}
+
+// isProvisioned returns if the server is provisioned, without provisioning it. Servers
+// that can't be looked up are logged and skipped (reported as not provisioned).
+func isProvisioned(name string, res *schema.Resource, l schema.Logger) bool {
+	if res.Manager.Provision == nil {
+		return true
+	}
+	if res.Manager.Lookup == nil {
+		l.Logf("Skipping %v: %v resources can't be found without provisioning them", name, res.Manager.Name)
+		return false
+	}
+	provisioned, err := res.Manager.Lookup(res.ManagerGroup, res.Resource, l)
+	if err != nil {
+		l.Logf("Could not look up %v: %v", name, err)
+		return false
+	}
+	if !provisioned {
+		l.Logf("Skipping %v: it isn't provisioned", name)
+	}
+	return provisioned
+}
+
+// fetchRemoteDeployments copies the deployment records stored on the servers in
+// the environment, that aren't in the local cache, to the local cache.
+func fetchRemoteDeployments(config *schema.Config, environment *schema.Environment) error {
+	setTemplateGlobals(config, environment)
+
+	local, err := loadDeployments(environment.Name)
+	if err != nil {
+		return err
+	}
+	found := make(map[int]*deployment)
+	for _, d := range local {
+		found[d.Number] = d
+	}
+	fetched := make([]*deployment, 0)
+	root := commandtree.NewRootCommand("Reading deployment records from " + environment.Name)
+	root.Add("Reading deployment records", commandtree.NewFuncCommand(func(c *commandtree.Command) {
+		dir := schema.DeploymentsPath + "/" + environment.Name
+		script := "for f in " + shellQuote(dir) + "/*.json; do if [ -f \"$f\" ]; then cat \"$f\"; fi; done"
+
+		for _, name := range sortKeys(environment.Resources) {
+			res := environment.Resources[name]
+			server, ok := res.Resource.(schema.ServerResource)
+			if !ok {
+				continue
+			}
+
+			// only servers that are already provisioned have records. Reading history never provisions.
+			if !isProvisioned(name, res, c) {
+				continue
+			}
+
+			connection, err := server.OpenConnection()
+			if err != nil {
+				c.Logf("Could not connect to %v: %v", name, err)
+				continue
+			}
+
+			c.Logf("Reading deployment records from %v", name)
+			var records []*deployment
+			_, err = sudoRetry(false, func(sudo bool, cmdPrefix string) error {
+				records = records[:0]
+				return connection.ExecutePipeCommand(cmdPrefix+"sh -c "+shellQuote(script), func(reader io.Reader, errorReader io.Reader, writer io.Writer) error {
+					decoder := json.NewDecoder(reader)
+					for {
+						d := &deployment{}
+						if err := decoder.Decode(d); err == io.EOF {
+							return nil
+						} else if err != nil {
+							return err
+						}
+						records = append(records, d)
+					}
+				})
+			})
+			connection.Close()
+			if err != nil {
+				c.Logf("Could not read deployment records from %v: %v", name, err)
+				continue
+			}
+
+			// servers left out of a deploy (see deployFilter) don't have its record, so all are read
+			for _, d := range records {
+				if _, exists := found[d.Number]; !exists {
+					found[d.Number] = d
+					fetched = append(fetched, d)
+				}
+			}
+		}
+	}))
+
+	r := commandtree.NewRunner(root, 1)
+	go r.Run(nil)
+	runUI(root)
+
+	for _, d := range fetched {
+		if err := saveDeployment(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dogoRollback deploys a previous deployment (the one before the current one if to
+// is 0), using the given options.
+func dogoRollback(config *schema.Config, environment *schema.Environment, to int, options deployOptions) (deployResult, error) {
+	deployments, err := deploymentHistory(config, environment)
+	if err != nil {
+		return deployResult{}, err
+	}
+	if len(deployments) == 0 {
+		return deployResult{}, fmt.Errorf("No deployments of %v have been recorded, so there is nothing to roll back to.", environment.Name)
+	}
+
+	// find the deployment to roll back to. Default is the one before the current one.
+	var target *deployment
+	if to == 0 {
+		target = rollbackTarget(deployments)
+		if target == nil {
+			return deployResult{}, fmt.Errorf("No deployment of %v from before the current one has been recorded, so there is nothing to roll back to.", environment.Name)
+		}
+	} else {
+		numbers := make([]string, 0, len(deployments))
+		for _, d := range deployments {
+			if d.Number == to {
+				target = d
+			}
+			numbers = append(numbers, strconv.Itoa(d.Number))
+		}
+		if target == nil {
+			return deployResult{}, neaterror.New(map[string]interface{}{
+				"recorded deployments": strings.Join(numbers, ", "),
+			}, "Deployment #%v of %v was not found.", to, environment.Name)
+		}
+	}
+
+	options.rollback = target
+	return dogoDeploy(config, environment, options), nil
+}
+
+// rollbackTarget returns the deployment to roll back to by default: the newest one from
+// before the one that's deployed now. After a rollback, what's deployed is the deployment
+// that was rolled back to, so rolling back again goes further back instead of returning
+// to the deployment that was just rolled back. Returns nil if there is none.
+func rollbackTarget(deployments []*deployment) *deployment {
+	if len(deployments) == 0 {
+		return nil
+	}
+	byNumber := make(map[int]*deployment)
+	for _, d := range deployments {
+		byNumber[d.Number] = d
+	}
+
+	current := deployments[len(deployments)-1]
+	deployed := current.Number
+	for current != nil && current.RollbackOf != 0 {
+		deployed = current.RollbackOf
+		current = byNumber[deployed]
+	}
+
+	for i := len(deployments) - 1; i >= 0; i-- {
+		if deployments[i].Number < deployed {
+			return deployments[i]
+		}
+	}
+	return nil
+}
+
+func dogoHistory(config *schema.Config, environment *schema.Environment) error {
+	deployments, err := deploymentHistory(config, environment)
+	if err != nil {
+		return err
+	}
+	if len(deployments) == 0 {
+		fmt.Println("No deployments of " + environment.Name + " have been recorded.")
+		return nil
+	}
+
+	for i := len(deployments) - 1; i >= 0; i-- {
+		d := deployments[i]
+		line := fmt.Sprintf("%v#%-4v%v %v", term.Bold, d.Number, term.Reset, d.Time.Local().Format("2006-01-02 15:04:05"))
+		if d.User != "" || d.Host != "" {
+			line += " by " + d.User + "@" + d.Host
+		}
+		if d.RollbackOf != 0 {
+			line += fmt.Sprintf(" (rollback to #%v)", d.RollbackOf)
+		}
+		if d.Git != nil {
+			line += " from " + shortCommit(d.Git.Commit)
+			if d.Git.Dirty {
+				line += " (with uncommitted changes)"
+			}
+		}
+		fmt.Println(line)
+
+		for _, name := range sortedRecordNames(d.Servers) {
+			record := d.Servers[name]
+			fmt.Printf("  %v: %v containers, %v files, %v firewall rules\n", name, len(record.Containers), len(record.Files), len(record.Firewall))
+			for _, container := range record.Containers {
+				id := strings.TrimPrefix(container.ImageID, "sha256:")
+				if len(id) > 12 {
+					id = id[:12]
+				}
+				name := container.Name
+				if container.Cron != "" {
+					name = "cron " + container.Cron
+				}
+				fmt.Println("    " + term.Blue + name + term.Reset + " " + container.Tag + " (" + id + ")")
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedRecordNames(m map[string]*schema.DeploymentRecord) []string {
+	arr := make([]string, 0, len(m))
+	for k := range m {
+		arr = append(arr, k)
+	}
+	sort.Strings(arr)
+	return arr
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestDeploymentRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dogodeployments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if deployments, err := loadDeployments("prod"); err != nil || len(deployments) != 0 {
+		t.Fatalf("expected no deployments, got %v (err: %v)", len(deployments), err)
+	}
+
+	environment := &schema.Environment{Name: "prod"}
+	for i := 0; i != 11; i++ {
+		d, err := newDeployment(environment, map[string]*deployCommand{
+			"web": &deployCommand{record: &schema.DeploymentRecord{
+				Containers: []*schema.ContainerRecord{{Name: "web", Tag: "web:latest", ImageID: "sha256:abc", Options: []string{"-p 80:80"}}},
+			}},
+			"db": &deployCommand{}, // not calculated (e.g. not a server)
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Number != i+1 {
+			t.Fatalf("expected deployment number %v, got %v", i+1, d.Number)
+		}
+		if err := saveDeployment(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the number is taken by another deploy
+	if err := saveDeployment(&deployment{Number: 11, Environment: "prod"}); err == nil {
+		t.Errorf("expected an error when the deployment record already exists")
+	}
+
+	deployments, err := loadDeployments("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 11 || deployments[9].Number != 10 || deployments[10].Number != 11 {
+		t.Fatalf("expected deployments 1..11 in order, got %v", len(deployments))
+	}
+	d := deployments[10]
+	if len(d.Servers) != 1 || d.Servers["web"] == nil {
+		t.Fatalf("expected a record for web only, got %v", d.Servers)
+	}
+	if c := d.Servers["web"].Containers[0]; c.ImageID != "sha256:abc" || len(c.Options) != 1 || c.Options[0] != "-p 80:80" {
+		t.Errorf("container record not read back correctly: %+v", c)
+	}
+
+	// a partial deploy keeps the previous records of the servers it didn't deploy to
+	environment.Resources = map[string]*schema.Resource{"web": {Name: "web"}, "api": {Name: "api"}}
+	partial, err := newDeployment(environment, map[string]*deployCommand{
+		"api": &deployCommand{record: &schema.DeploymentRecord{}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial.Servers) != 2 || partial.Servers["web"].Containers[0].ImageID != "sha256:abc" {
+		t.Errorf("expected the record of web to be kept, got %v", partial.Servers)
+	}
+}
+
+func TestDeploymentNumberFromServers(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDeployment(&deployment{Number: 4, Environment: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// deploys from other machines are only recorded on the servers
+	d, err := newDeployment(&schema.Environment{Name: "prod"}, map[string]*deployCommand{
+		"web": {lastDeployment: 7},
+		"db":  {lastDeployment: 6},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Number != 8 {
+		t.Errorf("expected deployment number 8, got %v", d.Number)
+	}
+
+	if last := lastDeploymentNumber([]string{"3.json", "12.json", "9.json", "notes.txt", "x.json"}); last != 12 {
+		t.Errorf("expected 12, got %v", last)
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	var deployments []*deployment
+	deploy := func(rollbackOf int) {
+		deployments = append(deployments, &deployment{Number: len(deployments) + 1, RollbackOf: rollbackOf})
+	}
+	target := func() int {
+		if d := rollbackTarget(deployments); d != nil {
+			return d.Number
+		}
+		return 0
+	}
+
+	if target() != 0 {
+		t.Errorf("expected nothing to roll back to without deployments")
+	}
+
+	// deploy, deploy, rollback, rollback
+	deploy(0)
+	if target() != 0 {
+		t.Errorf("expected nothing to roll back to after the first deployment")
+	}
+	deploy(0)
+	if target() != 1 {
+		t.Fatalf("expected a rollback to #1, got #%v", target())
+	}
+	deploy(1) // #3: rollback to #1
+	if target() != 0 {
+		t.Errorf("expected nothing to roll back to from #1, got #%v", target())
+	}
+
+	// deploy, rollback, rollback
+	deploy(0) // #4
+	if target() != 3 {
+		t.Fatalf("expected a rollback to #3, got #%v", target())
+	}
+	deploy(3) // #5: rollback to #3 (which is #1)
+	if target() != 0 {
+		t.Errorf("expected nothing to roll back to from #1, got #%v", target())
+	}
+
+	deployments = nil
+	deploy(0)
+	deploy(0)
+	deploy(0)
+	deploy(2) // #4: rollback to #2
+	if target() != 1 {
+		t.Errorf("expected the second rollback to go to #1, not back to #3, got #%v", target())
+	}
+}
+
+func TestIsProvisioned(t *testing.T) {
+	provisioned := false
+	manager := &schema.ResourceManager{
+		Name: "fake",
+		Provision: func(group interface{}, resource interface{}, l schema.Logger) error {
+			provisioned = true
+			return nil
+		},
+	}
+	res := &schema.Resource{Manager: manager}
+	l := &schema.ConsoleLogger{}
+
+	if isProvisioned("web", res, l) {
+		t.Errorf("expected servers that can't be looked up to be skipped")
+	}
+	manager.Lookup = func(group interface{}, resource interface{}, l schema.Logger) (bool, error) { return false, nil }
+	if isProvisioned("web", res, l) {
+		t.Errorf("expected servers that aren't provisioned to be skipped")
+	}
+	manager.Lookup = func(group interface{}, resource interface{}, l schema.Logger) (bool, error) { return true, nil }
+	if !isProvisioned("web", res, l) || provisioned {
+		t.Errorf("expected the server to be found without provisioning it")
+	}
+	if !isProvisioned("web", &schema.Resource{Manager: &schema.ResourceManager{}}, l) {
+		t.Errorf("expected servers without provisioning to always be provisioned")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// rollingFor finds the rolling deploy settings for the resource. The first package
+// (by name) with a rolling block is used, otherwise the environment's rolling block.
+// Returns the name of the group of servers that are deployed in batches together.
+func rollingFor(config *schema.Config, environment *schema.Environment, res *schema.Resource) (string, *schema.Rolling) {
+	packageNames := make([]string, 0, len(res.Packages))
+	for name := range res.Packages {
+		packageNames = append(packageNames, name)
+	}
+	sort.Strings(packageNames)
+	for _, name := range packageNames {
+		if pack, found := config.Packages[name]; found && pack.Rolling != nil {
+			return "package " + name, pack.Rolling
+		}
+	}
+	if environment.Rolling != nil {
+		return "environment " + environment.Name, environment.Rolling
+	}
+	return "", nil
+}
+
+// rollingRounds splits the servers with remote commands into rounds that are run
+// one after another. Servers with rolling deploy settings are split into batches,
+// and batch n of every group runs in round n. All other servers run in the first round.
+func rollingRounds(config *schema.Config, environment *schema.Environment, deployCommands map[string]*deployCommand) [][]*deployCommand {
+	names := make([]string, 0, len(deployCommands))
+	for name := range deployCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make(map[string][]*deployCommand)
+	rounds := make([][]*deployCommand, 0)
+	addToRound := func(round int, cmd *deployCommand) {
+		for len(rounds) <= round {
+			rounds = append(rounds, make([]*deployCommand, 0))
+		}
+		rounds[round] = append(rounds[round], cmd)
+	}
+	for _, name := range names {
+		cmd := deployCommands[name]
+		if cmd.step == deployStepDone || cmd.remoteCommands == nil || len(cmd.remoteCommands.Children) == 0 {
+			continue
+		}
+
+		group, rolling := rollingFor(config, environment, cmd.res)
+		cmd.rolling = rolling
+		if rolling == nil {
+			addToRound(0, cmd)
+			continue
+		}
+		groups[group] = append(groups[group], cmd)
+	}
+
+	for group, cmds := range groups {
+		batches := (len(cmds) + cmds[0].rolling.Batch - 1) / cmds[0].rolling.Batch
+		for i, cmd := range cmds {
+			batch := i / cmd.rolling.Batch
+			cmd.rollingBatch = fmt.Sprintf("batch %v of %v for %v", batch+1, batches, group)
+			addToRound(batch, cmd)
+		}
+	}
+
+	return rounds
+}
+
+// waitHealthy waits for the containers deployed to the server to be running,
+// and healthy if they have a health check.
+func (c *deployCommand) waitHealthy(timeout time.Duration) bool {
+	names := make([]string, 0)
+	for _, container := range c.record.Containers {
+		if container.Cron == "" && container.Name != "" {
+			names = append(names, shellQuote(container.Name))
+		}
+	}
+	if len(names) == 0 {
+		return true
+	}
+
+	c.Logf("Waiting up to %v for containers to be healthy", timeout)
+	command := "docker inspect --format '{{.Name}} {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}' " + strings.Join(names, " ")
+	deadline := time.Now().Add(timeout)
+	var unhealthy []string
+	for {
+		unhealthy = unhealthy[:0]
+		var output string
+		var err error
+		c.requireSudo, err = sudoRetry(c.requireSudo, func(sudo bool, cmdPrefix string) error {
+			output, err = c.connection.ExecuteCommand(cmdPrefix + command)
+			return err
+		})
+		if err != nil {
+			c.Errf("Could not check container health: %v", err)
+			return false
+		}
+
+		failed := false
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			name := strings.TrimPrefix(fields[0], "/")
+			status := fields[1]
+			health := ""
+			if len(fields) > 2 {
+				health = fields[2]
+			}
+			if status != "running" || (health != "" && health != "healthy") {
+				unhealthy = append(unhealthy, fmt.Sprintf("%v (%v)", name, strings.TrimSpace(status+" "+health)))
+			}
+			if health == "unhealthy" {
+				failed = true
+			}
+		}
+
+		if len(unhealthy) == 0 {
+			c.Logf("Containers are healthy")
+			return true
+		}
+		if failed || time.Now().After(deadline) {
+			c.Errf("Containers did not become healthy: %v. Stopping the deploy, servers in later batches were not changed.", strings.Join(unhealthy, ", "))
+			return false
+		}
+		time.Sleep(time.Second * 2)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestRollingRounds(t *testing.T) {
+	config := &schema.Config{Packages: map[string]*schema.Package{
+		"web": {Name: "web", Rolling: &schema.Rolling{Batch: 2}},
+		"db":  {Name: "db"},
+	}}
+	environment := &schema.Environment{Name: "prod"}
+
+	deployCommands := make(map[string]*deployCommand)
+	add := func(name string, packageName string, changes bool) {
+		cmd := &deployCommand{
+			name:           name,
+			res:            &schema.Resource{Name: name, Packages: map[string]bool{packageName: true}},
+			remoteCommands: commandtree.NewRootCommand("Remote Commands"),
+		}
+		if changes {
+			cmd.remoteCommands.Add("change", commandtree.NewFuncCommand(func(c *commandtree.Command) {}))
+		}
+		deployCommands[name] = cmd
+	}
+	add("web_1", "web", true)
+	add("web_2", "web", true)
+	add("web_3", "web", false) // no changes, so not part of a batch
+	add("web_4", "web", true)
+	add("db_1", "db", true)
+
+	rounds := rollingRounds(config, environment, deployCommands)
+	expected := [][]string{{"db_1", "web_1", "web_2"}, {"web_4"}}
+	if len(rounds) != len(expected) {
+		t.Fatalf("expected %v rounds, got %v", len(expected), len(rounds))
+	}
+	for i, round := range rounds {
+		names := make(map[string]bool)
+		for _, cmd := range round {
+			names[cmd.name] = true
+		}
+		if len(names) != len(expected[i]) {
+			t.Errorf("round %v: expected %v, got %v", i, expected[i], names)
+			continue
+		}
+		for _, name := range expected[i] {
+			if !names[name] {
+				t.Errorf("round %v: expected %v, got %v", i, expected[i], names)
+			}
+		}
+	}
+	if deployCommands["web_4"].rollingBatch != "batch 2 of 2 for package web" {
+		t.Errorf("unexpected batch description: %v", deployCommands["web_4"].rollingBatch)
+	}
+
+	// an environment level setting applies to all servers
+	environment.Rolling = &schema.Rolling{Batch: 1}
+	config.Packages["web"].Rolling = nil
+	if rounds := rollingRounds(config, environment, deployCommands); len(rounds) != 4 {
+		t.Errorf("expected 4 rounds, got %v", len(rounds))
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// scpLocation is either a local path, or a path on a server in an environment (env.server:path)
+type scpLocation struct {
+	environment *schema.Environment
+	server      string
+	path        string
+}
+
+func (l *scpLocation) String() string {
+	if l.environment == nil {
+		return l.path
+	}
+	return l.environment.Name + "." + l.server + ":" + l.path
+}
+
+// parseSCPLocation parses "env.server:path" into a remote location. Anything else is a local path.
+func parseSCPLocation(config *schema.Config, input string) (*scpLocation, error) {
+	if colon := strings.Index(input, ":"); colon != -1 {
+		parts := strings.Split(input[:colon], ".")
+		if len(parts) == 2 {
+			environment, found := config.Environments[parts[0]]
+			if !found {
+				return nil, fmt.Errorf("unknown environment: %v", parts[0])
+			}
+			remotePath := input[colon+1:]
+			if remotePath == "" {
+				remotePath = "."
+			}
+			return &scpLocation{environment: environment, server: parts[1], path: remotePath}, nil
+		}
+	}
+
+	// local paths are relative to where dogo was started, not the project folder.
+	localPath := invocationPath(input)
+	if strings.HasSuffix(input, string(filepath.Separator)) && !strings.HasSuffix(localPath, string(filepath.Separator)) {
+		localPath += string(filepath.Separator)
+	}
+	return &scpLocation{path: localPath}, nil
+}
+
+func dogoSCP(config *schema.Config, source *scpLocation, destination *scpLocation) error {
+	if (source.environment == nil) == (destination.environment == nil) {
+		return fmt.Errorf("Exactly one of SOURCE and DESTINATION must be a remote path in the form 'environment.server:path'")
+	}
+
+	remote := source
+	if destination.environment != nil {
+		remote = destination
+	}
+	setTemplateGlobals(config, remote.environment)
+
+	// find server
+	res, found := remote.environment.Resources[remote.server]
+	if !found {
+		return fmt.Errorf("Unknown server: %v", remote.server)
+	}
+	server, ok := res.Resource.(schema.ServerResource)
+	if !ok {
+		return fmt.Errorf("%v is not a server. It's a %v", res.Name, res.Manager.Name)
+	}
+
+	root := commandtree.NewRootCommand("Copy " + source.String() + " to " + destination.String())
+	root.Add("Copying", commandtree.NewFuncCommand(func(c *commandtree.Command) {
+		// provision if required.
+		if res.Manager.Provision != nil {
+			c.Logf("Provisioning %v (%v)", res.Name, res.Manager.Name)
+			if err := res.Manager.Provision(res.ManagerGroup, res.Resource, c); err != nil {
+				c.Err(err)
+				return
+			}
+		}
+
+		connection, err := server.OpenConnection()
+		if err != nil {
+			c.Err(err)
+			return
+		}
+		defer connection.Close()
+
+		if remote == destination {
+			err = scpUpload(c, connection, source.path, destination.path)
+		} else {
+			err = scpDownload(c, connection, source.path, destination.path)
+		}
+		if err != nil {
+			c.Err(err)
+		}
+	}))
+
+	r := commandtree.NewRunner(root, 1)
+	go r.Run(nil)
+	commandtree.ConsoleUI(root)
+
+	for _, cmd := range root.Children {
+		if cmd.AsCommand().AnyError() {
+			return fmt.Errorf("Copying %v to %v failed", source, destination)
+		}
+	}
+	return nil
+}
+
+// scpUpload copies the local file or folder to the server. If the remote path is
+// an existing folder, the source is copied into it.
+func scpUpload(c *commandtree.Command, connection schema.ServerConnection, localPath string, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	// find all files and folders to copy
+	type uploadFile struct {
+		local  string
+		remote string
+		info   os.FileInfo
+	}
+	useSudo := false
+	if remoteIsDir, _ := remoteIsDirectory(connection, &useSudo, remotePath); remoteIsDir || strings.HasSuffix(remotePath, "/") {
+		remotePath = path.Join(remotePath, filepath.Base(filepath.Clean(localPath)))
+	}
+	var files []*uploadFile
+	total := int64(0)
+	err = filepath.Walk(localPath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() || fi.IsDir() {
+			files = append(files, &uploadFile{local: p, remote: path.Join(remotePath, filepath.ToSlash(rel)), info: fi})
+			if !fi.IsDir() {
+				total += fi.Size()
+			}
+		} else {
+			c.Logf("Skipping %v (not a regular file)", p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		c.Logf("Uploading %v files/folders (%v bytes) to %v", len(files), total, remotePath)
+	}
+
+	// copy them
+	written := int64(0)
+	for _, f := range files {
+		if f.info.IsDir() {
+			useSudo, err = sudoRetry(useSudo, func(sudo bool, cmdPrefix string) error {
+				_, err := connection.ExecuteCommand(cmdPrefix + "mkdir -p " + shellQuote(f.remote))
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("Could not create folder %v: %v", f.remote, err)
+			}
+			continue
+		}
+
+		c.Logf("%v -> %v", f.local, f.remote)
+		useSudo, err = sudoRetry(useSudo, func(sudo bool, cmdPrefix string) error {
+			file, err := os.Open(f.local)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return connection.WriteFile(f.remote, f.info.Mode().Perm(), f.info.Size(), file, sudo, func(p float64) {
+				if total > 0 {
+					c.SetProgress((float64(written) + p*float64(f.info.Size())) / float64(total))
+				}
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("Could not upload %v: %v", f.local, err)
+		}
+		written += f.info.Size()
+	}
+	c.SetProgress(0)
+
+	return nil
+}
+
+// scpDownload copies the file or folder from the server to the local path. The
+// remote side is read via tar, so folders are copied recursively. If the local
+// path is an existing folder, the source is copied into it.
+func scpDownload(c *commandtree.Command, connection schema.ServerConnection, remotePath string, localPath string) error {
+	remotePath = path.Clean(remotePath)
+	remoteDir, remoteBase := path.Split(remotePath)
+	if remoteDir == "" {
+		remoteDir = "."
+	}
+
+	if info, err := os.Stat(localPath); (err == nil && info.IsDir()) || strings.HasSuffix(localPath, string(filepath.Separator)) {
+		localPath = filepath.Join(localPath, remoteBase)
+	}
+
+	count := 0
+	total := int64(0)
+	_, err := sudoRetry(false, func(sudo bool, cmdPrefix string) error {
+		count = 0
+		total = 0
+		return connection.ExecutePipeCommand(cmdPrefix+"tar -C "+shellQuote(remoteDir)+" -cf - "+shellQuote(remoteBase), func(reader io.Reader, errorReader io.Reader, writer io.Writer) error {
+			archive := tar.NewReader(reader)
+			for {
+				header, err := archive.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+
+				// map remote name to local path
+				name := path.Clean(header.Name)
+				if remoteBase != "." {
+					if name != remoteBase && !strings.HasPrefix(name, remoteBase+"/") {
+						return fmt.Errorf("Unexpected file in archive: %v", header.Name)
+					}
+					name = strings.TrimPrefix(name, remoteBase)
+				}
+				target := filepath.Join(localPath, filepath.FromSlash(name))
+
+				switch header.Typeflag {
+				case tar.TypeDir:
+					if err := os.MkdirAll(target, os.FileMode(header.Mode).Perm()|0700); err != nil {
+						return err
+					}
+				case tar.TypeReg:
+					c.Logf("%v -> %v", header.Name, target)
+					if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+						return err
+					}
+					file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+					if err != nil {
+						return err
+					}
+					n, err := io.Copy(file, archive)
+					file.Close()
+					if err != nil {
+						return err
+					}
+					count++
+					total += n
+				default:
+					c.Logf("Skipping %v (not a regular file)", header.Name)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("Could not read %v: %v", remotePath, err)
+	}
+	c.Logf("Downloaded %v files (%v bytes)", count, total)
+
+	return nil
+}
+
+// remoteIsDirectory checks if the given path is an existing directory on the server.
+func remoteIsDirectory(connection schema.ServerConnection, useSudo *bool, remotePath string) (bool, error) {
+	isDir := false
+	var err error
+	*useSudo, err = sudoRetry(*useSudo, func(sudo bool, cmdPrefix string) error {
+		output, err := connection.ExecuteCommand(cmdPrefix + "sh -c " + shellQuote("if [ -d "+shellQuote(remotePath)+" ]; then echo yes; else echo no; fi"))
+		isDir = strings.TrimSpace(output) == "yes"
+		return err
+	})
+	return isDir, err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"golang.org/x/crypto/ssh/terminal"
 
@@ -11,7 +12,7 @@ import (
 	"github.com/oliverkofoed/dogo/term"
 )
 
-func dogoSSH(config *schema.Config, environment *schema.Environment, target string) error {
+func dogoSSH(config *schema.Config, environment *schema.Environment, target string, container string) error {
 	setTemplateGlobals(config, environment)
 
 	// find server
@@ -33,6 +34,23 @@ func dogoSSH(config *schema.Config, environment *schema.Environment, target stri
 		return fmt.Errorf("Unknown server: %v", target)
 	}
 
+	// check that the container is declared on the server
+	if container != "" {
+		containers := dockerContainerNames(targetResource)
+		found := false
+		for _, name := range containers {
+			if name == container {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return neaterror.New(map[string]interface{}{
+				"available containers": strings.Join(containers, ", "),
+			}, "Unknown container '%v' on %v", container, target)
+		}
+	}
+
 	// provision if required.
 	if targetResource.Manager.Provision != nil {
 		fmt.Printf("Provisioning %v (%v)\n", targetResource.Name, targetResource.Manager.Name)
@@ -49,14 +67,27 @@ func dogoSSH(config *schema.Config, environment *schema.Environment, target stri
 		return err
 	}
 
+	defer connection.Close()
+
+	// figure out how to enter the container
+	shellCommand := ""
+	targetName := targetResource.Name
+	if container != "" {
+		shellCommand, err = containerShellCommand(connection, container)
+		if err != nil {
+			return err
+		}
+		targetName += "." + container
+	}
+
 	// Remove "connecting..." line and print banner
 	if term.IsTerminal {
 		term.MoveUp(1)
 		term.EraseCurrentLine()
 	}
-	fmt.Println(term.Bold + runChar(dashes, 30+len(targetResource.Manager.Name)+len(targetResource.Name)) + term.Reset)
-	fmt.Println(term.Bold + "-----[ connected to " + environment.Name + "." + targetResource.Name + term.Reset + " (" + targetResource.Manager.Name + ") ]-----" + term.Reset)
-	fmt.Println(term.Bold + runChar(dashes, 30+len(targetResource.Manager.Name)+len(targetResource.Name)) + term.Reset)
+	fmt.Println(term.Bold + runChar(dashes, 30+len(targetResource.Manager.Name)+len(targetName)) + term.Reset)
+	fmt.Println(term.Bold + "-----[ connected to " + environment.Name + "." + targetName + term.Reset + " (" + targetResource.Manager.Name + ") ]-----" + term.Reset)
+	fmt.Println(term.Bold + runChar(dashes, 30+len(targetResource.Manager.Name)+len(targetName)) + term.Reset)
 	fmt.Println()
 
 	fileDescriptor := int(os.Stdin.Fd())
@@ -76,5 +107,31 @@ func dogoSSH(config *schema.Config, environment *schema.Environment, target stri
 	}
 
 	// run a shell
-	return connection.Shell("", os.Stderr, os.Stdout, os.Stdin, width, height)
+	return connection.Shell(shellCommand, os.Stderr, os.Stdout, os.Stdin, width, height)
+}
+
+// containerShellCommand checks that the container is running, and returns the
+// command that starts an interactive shell inside it (with sudo if docker requires it).
+func containerShellCommand(connection schema.ServerConnection, container string) (string, error) {
+	running := ""
+	useSudo, err := sudoRetry(false, func(sudo bool, cmdPrefix string) error {
+		output, err := connection.ExecuteCommand(cmdPrefix + "docker inspect --format '{{.State.Running}}' " + shellQuote(container))
+		running = strings.TrimSpace(output)
+		return err
+	})
+	if err != nil {
+		if isCommandNotFound(err) {
+			return "", fmt.Errorf("Docker is not installed on the server")
+		}
+		return "", err
+	}
+	if running != "true" {
+		return "", fmt.Errorf("The container '%v' is not running", container)
+	}
+
+	cmdPrefix := ""
+	if useSudo {
+		cmdPrefix = "sudo -n "
+	}
+	return cmdPrefix + "docker exec -it " + shellQuote(container) + " sh -c " + shellQuote("if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"), nil
 }
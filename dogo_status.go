@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/oliverkofoed/dogo/term"
+)
+
+// serverStatus is the drift of a single server: the modules that are in sync
+// with the configuration, and the commands required to fix the ones that aren't.
+type serverStatus struct {
+	checked bool                       // false if the state couldn't be gathered or commands calculated
+	inSync  []string                   // names of the modules without changes
+	drifted map[string]*moduleCommands // modulename => commands to bring it back in sync
+	pending []string                   // the server must be provisioned or get a new dogoagent before modules can be checked
+}
+
+// statusOf finds the drift of a server from the commands calculated for it.
+// Returns nil if the resource isn't a server.
+func statusOf(cmd *deployCommand) *serverStatus {
+	if len(cmd.pending) > 0 {
+		return &serverStatus{checked: true, drifted: make(map[string]*moduleCommands), pending: cmd.pending}
+	}
+	if cmd.moduleCommands == nil && cmd.step == deployStepDone && !anyErrorInTree(cmd) {
+		return nil
+	}
+
+	status := &serverStatus{drifted: make(map[string]*moduleCommands)}
+	if cmd.moduleCommands == nil || anyErrorInTree(cmd) {
+		return status
+	}
+	status.checked = true
+
+	for moduleName, modules := range cmd.res.Modules {
+		if modules == nil || reflect.ValueOf(modules).Len() == 0 {
+			continue // not used on the server
+		}
+		if m, found := cmd.moduleCommands[moduleName]; found {
+			status.drifted[moduleName] = m
+		} else {
+			status.inSync = append(status.inSync, moduleName)
+		}
+	}
+	for moduleName, m := range cmd.moduleCommands { // modules that change state without config (e.g. removing all containers)
+		status.drifted[moduleName] = m
+	}
+	sort.Strings(status.inSync)
+	return status
+}
+
+// printStatus prints which servers and modules have drifted from the configuration.
+// Returns true if any have drifted. Servers that couldn't be checked aren't drift.
+func printStatus(environment *schema.Environment, deployCommands map[string]*deployCommand) bool {
+	fmt.Println()
+	fmt.Println(term.Bold + "Status of " + environment.Name + term.Reset)
+
+	drift := false
+	for _, name := range sortKeys(environment.Resources) {
+		cmd, found := deployCommands[name]
+		if !found {
+			continue
+		}
+		status := statusOf(cmd)
+		switch {
+		case status == nil:
+			continue
+		case !status.checked:
+			fmt.Println(term.Bold + environment.Name + "." + name + term.Red + " could not be checked" + term.Reset)
+			continue
+		case len(status.pending) > 0:
+			drift = true
+			fmt.Println(term.Bold + environment.Name + "." + name + term.Yellow + " drifted" + term.Reset)
+			for _, p := range status.pending {
+				fmt.Println("  " + term.Yellow + "+ " + term.Reset + p)
+			}
+			continue
+		case len(status.drifted) == 0:
+			fmt.Println(term.Bold + environment.Name + "." + name + term.Green + " in sync" + term.Reset)
+			continue
+		}
+
+		drift = true
+		fmt.Println(term.Bold + environment.Name + "." + name + term.Yellow + " drifted" + term.Reset)
+		for _, moduleName := range status.inSync {
+			fmt.Println("  " + moduleName + term.Green + " in sync" + term.Reset)
+		}
+		moduleNames := make([]string, 0, len(status.drifted))
+		for moduleName := range status.drifted {
+			moduleNames = append(moduleNames, moduleName)
+		}
+		sort.Strings(moduleNames)
+		for _, moduleName := range moduleNames {
+			m := status.drifted[moduleName]
+			fmt.Println("  " + moduleName + term.Yellow + " drifted" + term.Reset + ", to fix:")
+			for _, c := range m.local {
+				printPlanCommand(c, "    ", "(local) ")
+			}
+			for _, c := range m.remote {
+				printPlanCommand(c, "    ", "")
+			}
+		}
+	}
+
+	return drift
+}
+
+// statusJSON is the line written by 'dogo status' with --output=json
+type statusJSON struct {
+	Event       string                       `json:"event"`
+	Environment string                       `json:"environment"`
+	Drift       bool                         `json:"drift"`
+	Failed      bool                         `json:"failed"` // some servers couldn't be checked
+	Servers     map[string]*serverStatusJSON `json:"servers"`
+}
+
+type serverStatusJSON struct {
+	Checked bool                          `json:"checked"`
+	InSync  []string                      `json:"in_sync"`
+	Drifted map[string][]*planCommandJSON `json:"drifted"`           // module => commands to fix it
+	Pending []string                      `json:"pending,omitempty"` // e.g. would provision, would upgrade dogoagent
+}
+
+// printStatusJSON writes the drift of each server as a single JSON line.
+// Returns true if any have drifted. Servers that couldn't be checked aren't drift.
+func printStatusJSON(environment *schema.Environment, deployCommands map[string]*deployCommand) bool {
+	result := &statusJSON{
+		Event:       "status",
+		Environment: environment.Name,
+		Servers:     make(map[string]*serverStatusJSON),
+	}
+
+	for _, name := range sortKeys(environment.Resources) {
+		cmd, found := deployCommands[name]
+		if !found {
+			continue
+		}
+		status := statusOf(cmd)
+		if status == nil {
+			continue
+		}
+		s := &serverStatusJSON{
+			Checked: status.checked,
+			InSync:  status.inSync,
+			Drifted: make(map[string][]*planCommandJSON),
+			Pending: status.pending,
+		}
+		if s.InSync == nil {
+			s.InSync = make([]string, 0)
+		}
+		for moduleName, m := range status.drifted {
+			for _, c := range m.local {
+				s.Drifted[moduleName] = append(s.Drifted[moduleName], planCommandToJSON(c, true))
+			}
+			for _, c := range m.remote {
+				s.Drifted[moduleName] = append(s.Drifted[moduleName], planCommandToJSON(c, false))
+			}
+		}
+		if !status.checked {
+			result.Failed = true
+		} else if len(status.drifted) > 0 || len(status.pending) > 0 {
+			result.Drift = true
+		}
+		result.Servers[name] = s
+	}
+
+	json.NewEncoder(os.Stdout).Encode(result)
+	return result.Drift
+}
+
+// dogoStatus checks the servers of the environment for drift from the configuration.
+// It's a dry run, so nothing is provisioned or changed on the servers: servers that
+// aren't provisioned or run an old dogoagent are reported as drifted.
+func dogoStatus(config *schema.Config, environment *schema.Environment, options deployOptions) deployResult {
+	options.dryRun = true
+	options.status = true
+	return dogoDeploy(config, environment, options)
+}
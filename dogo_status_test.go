@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestStatusOf(t *testing.T) {
+	change := commandtree.NewFuncCommand(func(c *commandtree.Command) {})
+	cmd := &deployCommand{
+		step: deployStepCalculateCommands,
+		res: &schema.Resource{Modules: map[string]interface{}{
+			"docker":   []string{"web"},
+			"file":     []string{"/etc/app.conf"},
+			"firewall": []string{},
+		}},
+		moduleCommands: map[string]*moduleCommands{
+			"docker": {remote: []commandtree.CommandNode{change}},
+		},
+	}
+
+	status := statusOf(cmd)
+	if status == nil || !status.checked {
+		t.Fatalf("expected the server to be checked, got %+v", status)
+	}
+	if len(status.inSync) != 1 || status.inSync[0] != "file" {
+		t.Errorf("expected file to be in sync, got %v", status.inSync)
+	}
+	if len(status.drifted) != 1 || status.drifted["docker"] == nil {
+		t.Errorf("expected docker to have drifted, got %v", status.drifted)
+	}
+
+	// commands that couldn't be calculated means the server wasn't checked
+	cmd.moduleCommands = nil
+	if status := statusOf(cmd); status == nil || status.checked {
+		t.Errorf("expected the server to not be checked, got %+v", status)
+	}
+
+	// resources that aren't servers have no status
+	cmd.step = deployStepDone
+	if status := statusOf(cmd); status != nil {
+		t.Errorf("expected no status for resources that aren't servers, got %+v", status)
+	}
+}
+
+func TestStatusOfPending(t *testing.T) {
+	cmd := &deployCommand{step: deployStepDone, pending: []string{"would upgrade dogoagent to version 1"}}
+	status := statusOf(cmd)
+	if status == nil || !status.checked || len(status.pending) != 1 {
+		t.Errorf("expected a server needing a new agent to have drifted, got %+v", status)
+	}
+}
+
+func TestStatusUnchecked(t *testing.T) {
+	unreachable := &deployCommand{step: deployStepGatherState}
+	unreachable.Errf("could not connect")
+	environment := &schema.Environment{Name: "prod", Resources: map[string]*schema.Resource{"web": {}}}
+	deployCommands := map[string]*deployCommand{"web": unreachable}
+
+	// a server that couldn't be checked isn't drift, it's a failure (with its own exit code)
+	if printStatus(environment, deployCommands) {
+		t.Errorf("expected a server that couldn't be checked to not be drift")
+	}
+	if !anyFailed(deployCommands) {
+		t.Errorf("expected a server that couldn't be checked to be a failure")
+	}
+}
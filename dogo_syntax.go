@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oliverkofoed/dogo/constructor"
+	"github.com/oliverkofoed/dogo/neaterror"
+	"github.com/oliverkofoed/dogo/registry"
+	"github.com/oliverkofoed/dogo/schema"
+	"github.com/oliverkofoed/dogo/term"
+)
+
+// syntaxBlock is a block that can be used in .dogo config files
+type syntaxBlock struct {
+	name    string
+	kind    string
+	example string
+	fields  []constructor.Field
+}
+
+func dogoSyntax(name string, markdown bool) error {
+	blocks := syntaxBlocks()
+
+	// filter
+	if name != "" {
+		filtered := make([]*syntaxBlock, 0)
+		names := make([]string, 0)
+		for _, block := range blocks {
+			if block.name == name {
+				filtered = append(filtered, block)
+			}
+			if len(names) == 0 || names[len(names)-1] != block.name {
+				names = append(names, block.name)
+			}
+		}
+		if len(filtered) == 0 {
+			return neaterror.New(map[string]interface{}{
+				"available": strings.Join(names, ", "),
+			}, "Unknown module or resource: '%v'", name)
+		}
+		blocks = filtered
+	}
+
+	for i, block := range blocks {
+		if i > 0 {
+			fmt.Println()
+		}
+		if markdown {
+			printSyntaxMarkdown(block)
+		} else {
+			printSyntax(block)
+		}
+	}
+
+	return nil
+}
+
+// syntaxBlocks lists all modules and resources (and their groups) sorted by name, followed by other settings
+func syntaxBlocks() []*syntaxBlock {
+	blocks := make([]*syntaxBlock, 0)
+
+	moduleNames := make([]string, 0, len(registry.ModuleManagers))
+	for name := range registry.ModuleManagers {
+		moduleNames = append(moduleNames, name)
+	}
+	sort.Strings(moduleNames)
+	for _, name := range moduleNames {
+		manager := registry.ModuleManagers[name]
+		if manager.ModulePrototype == nil {
+			continue // modules without config (like 'dogo') can't be used in config files.
+		}
+		blocks = append(blocks, &syntaxBlock{
+			name:    name,
+			kind:    "module",
+			example: "package \"mypackage\" {\n  " + name + " {\n    ...\n  }\n}",
+			fields:  constructor.New(manager.ModulePrototype, nil).Fields(),
+		})
+	}
+
+	resourceNames := make([]string, 0, len(registry.ResourceManagers))
+	for name := range registry.ResourceManagers {
+		resourceNames = append(resourceNames, name)
+	}
+	sort.Strings(resourceNames)
+	for _, name := range resourceNames {
+		manager := registry.ResourceManagers[name]
+		blocks = append(blocks, &syntaxBlock{
+			name:    name,
+			kind:    "resource group",
+			example: "environment \"myenvironment\" {\n  " + name + " {\n    ...\n    server \"myserver\" { }\n  }\n}",
+			fields:  constructor.New(manager.GroupPrototype, nil).Fields(),
+		})
+		blocks = append(blocks, &syntaxBlock{
+			name:    name,
+			kind:    "resource",
+			example: "environment \"myenvironment\" {\n  " + name + " {\n    server \"myserver\" {\n      ...\n    }\n  }\n}",
+			fields:  constructor.New(manager.ResourcePrototype, nil).Fields(),
+		})
+	}
+
+	blocks = append(blocks, &syntaxBlock{
+		name:    "rolling",
+		kind:    "package or environment setting",
+		example: "package \"mypackage\" {\n  rolling {\n    ...\n  }\n}",
+		fields:  constructor.New(&schema.Rolling{}, nil).Fields(),
+	})
+	for _, hook := range []string{"before_deployment", "after_deployment", "before_server", "after_server"} {
+		blocks = append(blocks, &syntaxBlock{
+			name:    hook,
+			kind:    "deployment hook, the settings are given along with the arguments of the command",
+			example: "environment \"myenvironment\" {\n  " + hook + " \"mycommand\" {\n    ...\n  }\n}",
+			fields:  constructor.New(&schema.HookSettings{}, nil).Fields(),
+		})
+	}
+	blocks = append(blocks, &syntaxBlock{
+		name:    "parallel",
+		kind:    "environment setting",
+		example: "environment \"myenvironment\" {\n  parallel {\n    ...\n    linode = 2 # max servers provisioned at the same time by a resource manager\n  }\n}",
+		fields:  constructor.New(&schema.Parallel{}, nil).Fields(),
+	})
+	blocks = append(blocks, &syntaxBlock{
+		name:    "notify",
+		kind:    "environment setting, can be given more than once",
+		example: "environment \"myenvironment\" {\n  notify {\n    ...\n  }\n}",
+		fields:  constructor.New(&schema.Notify{}, nil).Fields(),
+	})
+
+	return blocks
+}
+
+func printSyntax(block *syntaxBlock) {
+	fmt.Println(term.Bold + block.name + term.Reset + " (" + block.kind + ")")
+	for _, line := range strings.Split(block.example, "\n") {
+		fmt.Println("  " + term.Blue + line + term.Reset)
+	}
+	if len(block.fields) == 0 {
+		fmt.Println("  (no properties)")
+		return
+	}
+
+	fmt.Println()
+	width := 0
+	for _, field := range block.fields {
+		if len(field.Name) > width {
+			width = len(field.Name)
+		}
+	}
+	for _, field := range block.fields {
+		fmt.Println("  " + field.Name + runChar(spaces, width-len(field.Name)) + "  " + syntaxFieldSummary(field))
+		if field.Description != "" {
+			fmt.Println("  " + runChar(spaces, width) + "  " + field.Description)
+		}
+	}
+}
+
+func printSyntaxMarkdown(block *syntaxBlock) {
+	fmt.Println("## " + block.name + " (" + block.kind + ")")
+	fmt.Println()
+	fmt.Println("```")
+	fmt.Println(block.example)
+	fmt.Println("```")
+	fmt.Println()
+	if len(block.fields) == 0 {
+		fmt.Println("No properties.")
+		return
+	}
+
+	fmt.Println("| Property | Type | Required | Default | Description |")
+	fmt.Println("|----------|------|----------|---------|-------------|")
+	for _, field := range block.fields {
+		required := ""
+		if field.Required {
+			required = "yes"
+		}
+		fmt.Println("| `" + field.Name + "` | " + field.Type + " | " + required + " | " + markdownEscape(syntaxFieldDefault(field)) + " | " + markdownEscape(field.Description) + " |")
+	}
+}
+
+func syntaxFieldSummary(field constructor.Field) string {
+	parts := []string{field.Type}
+	if field.Required {
+		parts = append(parts, "required")
+	}
+	if d := syntaxFieldDefault(field); d != "" {
+		parts = append(parts, "default: "+d)
+	}
+	return term.Yellow + strings.Join(parts, ", ") + term.Reset
+}
+
+func syntaxFieldDefault(field constructor.Field) string {
+	if field.Default != "" {
+		return fmt.Sprintf("%q", field.Default)
+	}
+	if field.DefaultEnv != "" {
+		return "$" + field.DefaultEnv
+	}
+	return ""
+}
+
+func markdownEscape(s string) string {
+	return strings.Replace(s, "|", "\\|", -1)
+}
@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// fakeDocker puts a docker script first in PATH that records its arguments,
+// and fails 'docker run' if failRun is true. Returns a func reading the calls.
+func fakeDocker(t *testing.T, failRun bool) func() []string {
+	dir, err := ioutil.TempDir("", "fakedocker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + log + "\n"
+	if failRun {
+		script += "if [ \"$1\" = run ]; then echo 'boom' >&2; exit 1; fi\n"
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	})
+	return func() []string {
+		b, _ := ioutil.ReadFile(log)
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+}
+
+func TestContainerCommandReplacesContainer(t *testing.T) {
+	calls := fakeDocker(t, false)
+	c := &containerCommand{Name: "web", StopContainerID: "abc", StartCommand: "docker run --detach --name web img"}
+	c.Execute()
+
+	if c.AnyError() {
+		t.Errorf("expected no errors, got %v", c.LogArray)
+	}
+	expected := []string{"rm --force web_dogo_previous", "stop abc", "rename abc web_dogo_previous", "run --detach --name web img", "rm web_dogo_previous"}
+	if got := calls(); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected calls %v, got %v", expected, got)
+	}
+}
+
+func TestContainerCommandRestoresPrevious(t *testing.T) {
+	calls := fakeDocker(t, true)
+	c := &containerCommand{Name: "web", StopContainerID: "abc", StartCommand: "docker run --detach --name web img"}
+	c.Execute()
+
+	if !c.AnyError() {
+		t.Errorf("expected the failed start to be reported as an error")
+	}
+	expected := []string{"rm --force web_dogo_previous", "stop abc", "rename abc web_dogo_previous", "run --detach --name web img", "rm --force web", "rename web_dogo_previous web", "start web"}
+	if got := calls(); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected calls %v, got %v", expected, got)
+	}
+	restored := false
+	for _, entry := range c.LogArray {
+		if strings.Contains(entry.Message, "Previous container restored") {
+			restored = true
+		}
+	}
+	if !restored {
+		t.Errorf("expected the restore to be logged")
+	}
+}
+
+// fakeDockerAPI points DOCKER_HOST to a server answering the docker API calls
+// used to create and start containers (and networks), and fails starting them if failStart is
+// true. Returns a func reading the calls ("METHOD path") and the created configs.
+func fakeDockerAPI(t *testing.T, failStart bool) func() ([]string, []map[string]interface{}) {
+	var mutex sync.Mutex
+	calls := make([]string, 0)
+	created := make([]map[string]interface{}, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/v1.") {
+			path = path[strings.Index(path[1:], "/")+1:] // without the api version
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, r.Method+" "+path)
+
+		w.Header().Set("API-Version", "1.41")
+		switch {
+		case path == "/containers/create":
+			body := make(map[string]interface{})
+			json.NewDecoder(r.Body).Decode(&body)
+			created = append(created, body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"new123","Warnings":[]}`))
+		case path == "/networks/create":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"net123"}`))
+		case strings.HasSuffix(path, "/start") && failStart:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message":"port is already allocated"}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	host := os.Getenv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+	t.Cleanup(func() {
+		os.Setenv("DOCKER_HOST", host)
+		server.Close()
+	})
+	return func() ([]string, []map[string]interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls, created
+	}
+}
+
+func TestContainerCommandCreatesWithAPI(t *testing.T) {
+	calls := fakeDockerAPI(t, false)
+	spec, err := newContainerSpec(&schema.ContainerRecord{Command: "serve --port 80", Env: map[string]string{"MODE": "it's live"}}, "sha256:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &containerCommand{Name: "web", Spec: spec}
+	c.Execute()
+
+	if c.AnyError() {
+		t.Errorf("expected no errors, got %v", c.LogArray)
+	}
+	requests, created := calls()
+	if len(created) != 1 || created[0]["Image"] != "sha256:abc" {
+		t.Fatalf("expected the container to be created from the image, got %v", created)
+	}
+	if env := created[0]["Env"].([]interface{}); len(env) != 1 || env[0] != "MODE=it's live" {
+		t.Errorf("expected the env to be passed without quoting, got %v", env)
+	}
+	if !strings.Contains(strings.Join(requests, "|"), "POST /containers/new123/start") {
+		t.Errorf("expected the container to be started, got %v", requests)
+	}
+}
+
+func TestContainerCommandRemovesFailedStart(t *testing.T) {
+	calls := fakeDockerAPI(t, true)
+	c := &containerCommand{Name: "web", Spec: &containerSpec{Image: "sha256:abc"}}
+	c.Execute()
+
+	if !c.AnyError() {
+		t.Errorf("expected the failed start to be reported as an error")
+	}
+	if requests, _ := calls(); !strings.Contains(strings.Join(requests, "|"), "DELETE /containers/new123") {
+		t.Errorf("expected the failed container to be removed, got %v", requests)
+	}
+}
+
+func TestGitLabels(t *testing.T) {
+	if labels := gitLabels(nil); labels != nil {
+		t.Errorf("expected no labels without git, got %v", labels)
+	}
+	labels := gitLabels(&schema.GitInfo{Commit: "abc", Branch: "it's", Dirty: true})
+	if len(labels) != 3 || labels["dogo.git.commit"] != "abc" || labels["dogo.git.branch"] != "it's" || labels["dogo.git.dirty"] != "true" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+}
+
+func TestContainerCommandLoadsImage(t *testing.T) {
+	calls := fakeDocker(t, false)
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	ioutil.WriteFile(archive, []byte("image"), 0600)
+
+	c := &containerCommand{LoadFile: archive}
+	c.Execute()
+	again := &containerCommand{LoadFile: archive} // another container using the same image
+	again.Execute()
+
+	if c.AnyError() || again.AnyError() {
+		t.Errorf("expected no errors, got %v %v", c.LogArray, again.LogArray)
+	}
+	if got := calls(); strings.Join(got, "|") != "load --input "+archive {
+		t.Errorf("expected the image to be loaded once, got %v", got)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("expected the archive to be removed after loading")
+	}
+}
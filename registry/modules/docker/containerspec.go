@@ -0,0 +1,313 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// containerSpec is how a container is created with the docker API. It's built
+// from the rendered ContainerRecord, so the agent doesn't need a shell to start it.
+type containerSpec struct {
+	Image      string
+	Cmd        []string
+	Entrypoint []string
+	Env        map[string]string
+	Labels     map[string]string
+	Ports      []string
+	Volumes    []string
+	Restart    string
+	Network    string
+	Aliases    []string
+	Memory     string
+	CPUs       string
+	User       string
+}
+
+// Validate checks the settings of the module that aren't templates
+func (d *Docker) Validate() error {
+	if _, _, err := nat.ParsePortSpecs(d.Ports); err != nil {
+		return fmt.Errorf("ports: %v", err)
+	}
+	if _, err := parseRestart(d.Restart); err != nil {
+		return err
+	}
+	if _, err := parseMemory(d.Memory); err != nil {
+		return err
+	}
+	if _, err := parseCPUs(d.Cpus); err != nil {
+		return err
+	}
+	if d.KeepLast < 0 {
+		return fmt.Errorf("keep_last can't be negative. Got: %v", d.KeepLast)
+	}
+	for key := range d.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return fmt.Errorf("env: invalid variable name '%v'", key)
+		}
+	}
+	for key := range d.Labels {
+		if strings.HasPrefix(key, "dogo") {
+			return fmt.Errorf("labels: labels starting with 'dogo' are reserved. Got: %v", key)
+		}
+	}
+	return nil
+}
+
+// newContainerSpec builds the spec of the container from its record
+func newContainerSpec(record *schema.ContainerRecord, imageID string) (*containerSpec, error) {
+	spec := &containerSpec{
+		Image:   imageID,
+		Env:     record.Env,
+		Labels:  make(map[string]string),
+		Ports:   record.Ports,
+		Volumes: record.Volumes,
+		Restart: record.Restart,
+		Network: record.Network,
+		Aliases: record.Aliases,
+		Memory:  record.Memory,
+		CPUs:    record.CPUs,
+		User:    record.User,
+	}
+	for key, value := range record.Labels {
+		spec.Labels[key] = value
+	}
+
+	var err error
+	if strings.ContainsAny(record.Command, shellMetacharacters) {
+		spec.Cmd = []string{"sh", "-c", record.Command}
+	} else if spec.Cmd, err = splitWords(record.Command); err != nil {
+		return nil, fmt.Errorf("command: %v", err)
+	}
+	if spec.Entrypoint, err = splitWords(record.Entrypoint); err != nil {
+		return nil, fmt.Errorf("entrypoint: %v", err)
+	}
+	for _, volume := range spec.Volumes {
+		if len(strings.Split(volume, ":")) < 2 {
+			return nil, fmt.Errorf("volumes: '%v' must be 'source:destination' or 'source:destination:options'", volume)
+		}
+	}
+	if len(spec.Aliases) > 0 && (spec.Network == "" || !container.NetworkMode(spec.Network).IsUserDefined()) {
+		return nil, fmt.Errorf("aliases only work on user defined networks (such as the 'dogo_...' networks), not '%v'", spec.Network)
+	}
+	if _, _, _, err := spec.config(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// config returns the spec as docker API configs
+func (s *containerSpec) config() (*container.Config, *container.HostConfig, *network.NetworkingConfig, error) {
+	exposed, bindings, err := nat.ParsePortSpecs(s.Ports)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("ports: %v", err)
+	}
+	restart, err := parseRestart(s.Restart)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	memory, err := parseMemory(s.Memory)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cpus, err := parseCPUs(s.CPUs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	config := &container.Config{
+		Image:        s.Image,
+		Cmd:          s.Cmd,
+		Entrypoint:   s.Entrypoint,
+		Labels:       s.Labels,
+		User:         s.User,
+		ExposedPorts: exposed,
+	}
+	for _, key := range sortedKeys(s.Env) {
+		config.Env = append(config.Env, key+"="+s.Env[key])
+	}
+
+	hostConfig := &container.HostConfig{
+		Binds:         s.Volumes,
+		PortBindings:  bindings,
+		RestartPolicy: restart,
+		NetworkMode:   container.NetworkMode(s.Network),
+	}
+	hostConfig.Memory = memory
+	hostConfig.NanoCPUs = cpus
+
+	var networkingConfig *network.NetworkingConfig
+	if len(s.Aliases) > 0 {
+		networkingConfig = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+			s.Network: {Aliases: s.Aliases},
+		}}
+	}
+	return config, hostConfig, networkingConfig, nil
+}
+
+// runArgs returns the spec as 'docker run' options. Only the entrypoint
+// executable is an option, the rest of the entrypoint is returned as the
+// arguments that go before the command.
+func (s *containerSpec) runArgs() (options []string, entrypointArgs []string) {
+	for _, key := range sortedKeys(s.Labels) {
+		options = append(options, "--label", key+"="+s.Labels[key])
+	}
+	for _, port := range s.Ports {
+		options = append(options, "--publish", port)
+	}
+	for _, key := range sortedKeys(s.Env) {
+		options = append(options, "--env", key+"="+s.Env[key])
+	}
+	for _, volume := range s.Volumes {
+		options = append(options, "--volume", volume)
+	}
+	for _, option := range [][2]string{{"--restart", s.Restart}, {"--network", s.Network}, {"--memory", s.Memory}, {"--cpus", s.CPUs}, {"--user", s.User}} {
+		if option[1] != "" {
+			options = append(options, option[0], option[1])
+		}
+	}
+	for _, alias := range s.Aliases {
+		options = append(options, "--network-alias", alias)
+	}
+	if len(s.Entrypoint) > 0 {
+		options = append(options, "--entrypoint", s.Entrypoint[0])
+		entrypointArgs = s.Entrypoint[1:]
+	}
+	return options, entrypointArgs
+}
+
+// runCommand returns the 'docker run' command line that starts the container like the spec.
+// The command is given as-is, so it's split (and expanded) by the shell running the command line.
+func (s *containerSpec) runCommand(runOptions []string, extraOptions []string, command string) string {
+	options, entrypointArgs := s.runArgs()
+	words := append(append([]string{"docker", "run"}, runOptions...), options...)
+	line := quoteWords(words)
+	for _, option := range extraOptions {
+		line += " " + option
+	}
+	line += " " + quoteWords(append([]string{s.Image}, entrypointArgs...))
+	if command != "" {
+		line += " " + command
+	}
+	return line
+}
+
+func parseRestart(restart string) (container.RestartPolicy, error) {
+	policy := container.RestartPolicy{Name: restart}
+	switch {
+	case restart == "" || restart == "no" || restart == "always" || restart == "unless-stopped" || restart == "on-failure":
+		return policy, nil
+	case strings.HasPrefix(restart, "on-failure:"):
+		retries, err := strconv.Atoi(strings.TrimPrefix(restart, "on-failure:"))
+		if err != nil || retries < 1 {
+			return policy, fmt.Errorf("restart: the max retries of on-failure must be a number above 0. Got: %v", restart)
+		}
+		return container.RestartPolicy{Name: "on-failure", MaximumRetryCount: retries}, nil
+	}
+	return policy, fmt.Errorf("restart must be no, always, unless-stopped, on-failure or on-failure:N. Got: %v", restart)
+}
+
+func parseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(memory)
+	if err != nil {
+		return 0, fmt.Errorf("memory must be a size such as '512m' or '2g'. Got: %v", memory)
+	}
+	return bytes, nil
+}
+
+func parseCPUs(cpus string) (int64, error) {
+	if cpus == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("cpus must be a number above 0, such as '1.5'. Got: %v", cpus)
+	}
+	return int64(value * 1e9), nil
+}
+
+// shellMetacharacters are the characters that need a shell. Commands with any of them
+// are run with 'sh -c' in the container, so variables, pipes, && and globs keep working
+// (they were run by a shell before containers were created with the docker API).
+const shellMetacharacters = "$&|;<>*?[`()~#"
+
+// splitWords splits the string into words the way a shell would, honoring
+// quotes and backslashes. Nothing is expanded.
+func splitWords(s string) ([]string, error) {
+	words := make([]string, 0)
+	word := strings.Builder{}
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape in: " + s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+var safeShellWord = regexp.MustCompile(`^[a-zA-Z0-9_./:=@%+,-]+$`)
+
+// quoteWords quotes the words for use in a shell command
+func quoteWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		if safeShellWord.MatchString(w) {
+			quoted[i] = w
+		} else {
+			quoted[i] = "'" + strings.Replace(w, "'", "'\\''", -1) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestSplitWords(t *testing.T) {
+	for input, expected := range map[string][]string{
+		"":                           {},
+		"nginx -g 'daemon off;'":     {"nginx", "-g", "daemon off;"},
+		`sh -c "echo \"hi\" && ls"`:  {"sh", "-c", `echo "hi" && ls`},
+		`a\ b  c`:                    {"a b", "c"},
+		"run ''":                     {"run", ""},
+		"  --port=80\t--verbose  \n": {"--port=80", "--verbose"},
+	} {
+		words, err := splitWords(input)
+		if err != nil || fmt.Sprintf("%q", words) != fmt.Sprintf("%q", expected) {
+			t.Errorf("splitWords(%q): expected %q, got %q (%v)", input, expected, words, err)
+		}
+	}
+	if _, err := splitWords("echo 'unterminated"); err == nil {
+		t.Errorf("expected an error for an unterminated quote")
+	}
+}
+
+func TestDockerValidate(t *testing.T) {
+	valid := &Docker{Ports: []string{"8080:80", "127.0.0.1:53:53/udp"}, Restart: "on-failure:3", Memory: "512m", Cpus: "1.5"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected valid settings, got %v", err)
+	}
+
+	for _, invalid := range []*Docker{
+		{Ports: []string{"80:eighty"}},
+		{Restart: "sometimes"},
+		{Restart: "on-failure:0"},
+		{Memory: "lots"},
+		{Cpus: "-1"},
+		{Labels: map[string]schema.Template{"dogo.version": nil}},
+		{Env: map[string]schema.Template{"A=B": nil}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestContainerSpec(t *testing.T) {
+	record := &schema.ContainerRecord{
+		Command:    "serve --config '/etc/my app.conf'",
+		Entrypoint: "/bin/tini --",
+		Ports:      []string{"8080:80"},
+		Env:        map[string]string{"B": "two words", "A": "1"},
+		Volumes:    []string{"/data:/data:ro"},
+		Restart:    "on-failure:5",
+		Network:    "backend",
+		Labels:     map[string]string{"team": "web"},
+		Memory:     "1g",
+		CPUs:       "0.5",
+		User:       "1000:1000",
+	}
+	spec, err := newContainerSpec(record, "sha256:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, hostConfig, networkingConfig, err := spec.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Image != "sha256:abc" || strings.Join(config.Cmd, "|") != "serve|--config|/etc/my app.conf" || strings.Join(config.Entrypoint, "|") != "/bin/tini|--" {
+		t.Errorf("unexpected image, cmd or entrypoint: %v %v %v", config.Image, config.Cmd, config.Entrypoint)
+	}
+	if strings.Join(config.Env, "|") != "A=1|B=two words" || config.User != "1000:1000" || config.Labels["team"] != "web" {
+		t.Errorf("unexpected env, user or labels: %v %v %v", config.Env, config.User, config.Labels)
+	}
+	if bindings := hostConfig.PortBindings["80/tcp"]; len(bindings) != 1 || bindings[0].HostPort != "8080" {
+		t.Errorf("unexpected port bindings: %v", hostConfig.PortBindings)
+	}
+	if hostConfig.RestartPolicy.Name != "on-failure" || hostConfig.RestartPolicy.MaximumRetryCount != 5 {
+		t.Errorf("unexpected restart policy: %+v", hostConfig.RestartPolicy)
+	}
+	if networkingConfig != nil {
+		t.Errorf("expected no networking config without aliases, got %+v", networkingConfig)
+	}
+	if hostConfig.Memory != 1<<30 || hostConfig.NanoCPUs != 5e8 || string(hostConfig.NetworkMode) != "backend" || hostConfig.Binds[0] != "/data:/data:ro" {
+		t.Errorf("unexpected host config: %+v", hostConfig)
+	}
+
+	expected := "docker run --rm --label team=web --publish 8080:80 --env A=1 --env 'B=two words' --volume /data:/data:ro --restart on-failure:5 --network backend --memory 1g --cpus 0.5 --user 1000:1000 --entrypoint /bin/tini --log-opt max-size=1m sha256:abc -- echo $HOME"
+	if line := spec.runCommand([]string{"--rm"}, []string{"--log-opt max-size=1m"}, "echo $HOME"); line != expected {
+		t.Errorf("unexpected run command:\nexpected: %v\n     got: %v", expected, line)
+	}
+
+	if _, err := newContainerSpec(&schema.ContainerRecord{Volumes: []string{"/data"}}, "sha256:abc"); err == nil {
+		t.Errorf("expected an error for a volume without a destination")
+	}
+}
+
+func TestContainerSpecShellCommand(t *testing.T) {
+	for command, expected := range map[string]string{
+		"serve --port 80":                 "serve|--port|80",
+		"serve --port $PORT":              "sh|-c|serve --port $PORT",
+		"migrate && serve":                "sh|-c|migrate && serve",
+		"cat /etc/app/*.conf | grep port": "sh|-c|cat /etc/app/*.conf | grep port",
+	} {
+		spec, err := newContainerSpec(&schema.ContainerRecord{Command: command}, "sha256:abc")
+		if err != nil || strings.Join(spec.Cmd, "|") != expected {
+			t.Errorf("command %q: expected %v, got %q (%v)", command, expected, spec.Cmd, err)
+		}
+	}
+}
+
+func TestContainerSpecAliases(t *testing.T) {
+	spec, err := newContainerSpec(&schema.ContainerRecord{Network: "dogo_backend", Aliases: []string{"db", "postgres"}}, "sha256:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, networkingConfig, err := spec.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := networkingConfig.EndpointsConfig["dogo_backend"]; endpoint == nil || strings.Join(endpoint.Aliases, "|") != "db|postgres" {
+		t.Errorf("expected the aliases on the network endpoint, got %+v", networkingConfig.EndpointsConfig)
+	}
+	if line := spec.runCommand(nil, nil, ""); line != "docker run --network dogo_backend --network-alias db --network-alias postgres sha256:abc" {
+		t.Errorf("unexpected run command: %v", line)
+	}
+
+	for _, network := range []string{"", "bridge", "host"} {
+		if _, err := newContainerSpec(&schema.ContainerRecord{Network: network, Aliases: []string{"db"}}, "sha256:abc"); err == nil {
+			t.Errorf("expected an error for aliases on the network '%v'", network)
+		}
+	}
+}
+
+func TestContainerVersion(t *testing.T) {
+	// containers without the structured settings keep the version they had before the settings existed
+	record := &schema.ContainerRecord{Command: "serve", Folder: "web", Options: []string{"-p 80:80"}}
+	h := sha1.New()
+	h.Write([]byte("serve"))
+	h.Write([]byte("sha256:abc"))
+	h.Write([]byte("web"))
+	h.Write([]byte("-p 80:80"))
+	if version := containerVersion(record, "sha256:abc"); version != fmt.Sprintf("%x", h.Sum(nil)) {
+		t.Errorf("expected the version to be unchanged, got %v", version)
+	}
+
+	withEnv := *record
+	withEnv.Env = map[string]string{"A": ""}
+	if containerVersion(&withEnv, "sha256:abc") == containerVersion(record, "sha256:abc") {
+		t.Errorf("expected env to change the version")
+	}
+	withMemory := *record
+	withMemory.Memory = "1g"
+	if containerVersion(&withMemory, "sha256:abc") == containerVersion(record, "sha256:abc") {
+		t.Errorf("expected memory to change the version")
+	}
+	withAliases := *record
+	withAliases.Aliases = []string{"db"}
+	if containerVersion(&withAliases, "sha256:abc") == containerVersion(record, "sha256:abc") {
+		t.Errorf("expected aliases to change the version")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -32,6 +33,17 @@ import (
 var notInstalledErr = errors.New("not installed")
 var cronFile = "/etc/cron.d/dogodocker"
 
+// previousContainerSuffix is added to the name of a replaced container, until its replacement is running
+const previousContainerSuffix = "_dogo_previous"
+
+// labels with the git state of the deployed project. They're not part of the
+// container version, so a new commit alone doesn't restart containers.
+const (
+	labelGitCommit = "dogo.git.commit"
+	labelGitBranch = "dogo.git.branch"
+	labelGitDirty  = "dogo.git.dirty"
+)
+
 type Docker struct {
 	// which image to run
 	Folder schema.Template
@@ -42,16 +54,39 @@ type Docker struct {
 	CronUser schema.Template
 
 	// how the container should be configured.
-	Name    schema.Template `required:"yes" description:"The container name to use."`
-	Command schema.Template
-	Options []schema.Template
+	Name       schema.Template            `required:"yes" description:"The container name to use."`
+	Command    schema.Template            `description:"The command to run. It's run with 'sh -c' in the container if it uses the shell ($VAR, &&, pipes, globs...), otherwise it's split into words like a shell would"`
+	Entrypoint schema.Template            `description:"Overrides the entrypoint of the image, split into words like the command"`
+	Ports      []string                   `description:"Ports to publish, e.g. '8080:80', '127.0.0.1:8080:80' or '53:53/udp'"`
+	Env        map[string]schema.Template `description:"Environment variables, e.g. 'env { PORT = 8080 }'"`
+	Volumes    []schema.Template          `description:"Volumes or folders to mount, e.g. 'data:/var/lib/data' or '/etc/app:/etc/app:ro'"`
+	Restart    string                     `description:"The restart policy: no, always, unless-stopped, on-failure or on-failure:N"`
+	Network    schema.Template            `description:"The network to connect the container to. Networks named 'dogo_...' are created (and removed when unused) by dogo"`
+	Aliases    []schema.Template          `description:"Other names the container can be reached by on its network. It can always be reached by its name"`
+	Labels     map[string]schema.Template `description:"Labels to set on the container. Labels starting with 'dogo' are reserved"`
+	Memory     string                     `description:"The memory limit, e.g. '512m' or '2g'"`
+	Cpus       string                     `description:"The number of cpus the container can use, e.g. '1.5'"`
+	User       schema.Template            `description:"The user (and optionally group) to run as, e.g. 'app' or '1000:1000'"`
+	Options    []schema.Template          `description:"Extra options for 'docker run', used as-is. Containers with options are started with 'docker run' instead of the docker API"`
+
+	// how to check that the container started correctly
+	HealthCheck *HealthCheck `description:"Checked after starting the container. The deploy fails if the container doesn't become healthy."`
+
+	// cleaning up the server
+	GC       bool `name:"gc" description:"Remove unused images, and the stopped containers left over by interrupted deploys, from the server when deploying"`
+	KeepLast int  `name:"keep_last" default:"2" description:"With gc: keep the images of this many of the last deployments, so they can be rolled back to"`
 }
 
 type state struct {
-	Installed  bool
-	Containers []types.Container
-	Images     []types.ImageSummary
-	Cron       []byte
+	Installed   bool
+	Containers  []types.Container
+	Images      []types.ImageSummary
+	Networks    []networkState
+	Layers      []string // chain ids of the layers of the images
+	Containerd  bool     // images are stored by containerd, so archives must have every layer
+	ImageFiles  []string // image archives left by deploys that stopped before loading them
+	Deployments []deployedImages
+	Cron        []byte
 }
 
 // Manager is the main entry point to this Dogo Module
@@ -60,10 +95,15 @@ var Manager = schema.ModuleManager{
 	ModulePrototype: &Docker{},
 	StatePrototype:  &state{},
 	GobRegister: func() {
-		snobgob.Register(&startDockerRegistryAndSSHTunnelCommand{})
-		snobgob.Register(&dockerTagPushCommand{})
+		snobgob.Register(&sendImageCommand{})
 		snobgob.Register(&containerCommand{})
+		snobgob.Register(&containerSpec{})
+		snobgob.Register(&createNetworksCommand{})
+		snobgob.Register(&removeNetworksCommand{})
+		snobgob.Register(&schema.HealthCheck{})
 		snobgob.Register(&removeImagesCommand{})
+		snobgob.Register(&removeImageFilesCommand{})
+		snobgob.Register(&removeContainersCommand{})
 		snobgob.Register(&installDockerCommand{})
 		snobgob.Register(&writeCronCommand{})
 	},
@@ -97,6 +137,41 @@ var Manager = schema.ModuleManager{
 		}
 		state.Images = images
 
+		// the layers of the images, so only missing layers are sent
+		info, err := client.Info(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("Could not get Docker info. Error: %v", err.Error())
+		}
+		state.Containerd = containerdImageStore(info.DriverStatus)
+		layers := make(map[string]bool)
+		for _, img := range images {
+			inspect, _, err := client.ImageInspectWithRaw(context.Background(), img.ID)
+			if err != nil {
+				continue // removed since it was listed
+			}
+			for _, chain := range chainIDs(inspect.RootFS.Layers) {
+				if !layers[chain] {
+					layers[chain] = true
+					state.Layers = append(state.Layers, chain)
+				}
+			}
+		}
+
+		// list networks
+		if state.Networks, err = listNetworks(client); err != nil {
+			return nil, fmt.Errorf("Could not list Docker Networks. Error: %v", err.Error())
+		}
+
+		// images used by the deployments recorded on the server
+		if state.Deployments, err = readDeployedImages(schema.DeploymentsPath); err != nil {
+			return nil, fmt.Errorf("Could not read deployment records (%v). Error: %v", schema.DeploymentsPath, err.Error())
+		}
+
+		// image archives that were never loaded
+		if state.ImageFiles, err = staleImageFiles(imageFilesPattern, time.Now()); err != nil {
+			return nil, fmt.Errorf("Could not list image archives (%v). Error: %v", imageFilesPattern, err.Error())
+		}
+
 		state.Cron = []byte{}
 		if _, err := os.Stat(cronFile); err == nil {
 			cronfileBytes, err := ioutil.ReadFile(cronFile)
@@ -117,22 +192,72 @@ var Manager = schema.ModuleManager{
 		// the cron commands to be installed on remote machine
 		cronCommands := make([]string, 0)
 
+		// render the containers (or use the ones from the deployment we're rolling back to)
+		records := make([]*schema.ContainerRecord, 0, len(modules))
+		if c.Rollback != nil {
+			records = c.Rollback.Containers
+		} else {
+			for _, module := range modules {
+				record, err := renderContainer(module)
+				if err != nil {
+					return err
+				}
+				records = append(records, record)
+			}
+		}
+
 		// nothing to do,
-		if len(modules) == 0 && !remoteState.Installed {
+		if len(records) == 0 && !remoteState.Installed {
 			return nil
 		}
 
+		// stop and remove the containers that are no longer declared, before starting
+		// new ones (which might need their ports)
+		remoteRoot := c.RemoteCommands.AsCommand()
+		removed := make(map[string]bool)
+		if undeclared := undeclaredContainers(remoteState, records); len(undeclared) > 0 {
+			remove := &removeContainersCommand{Reason: "no longer declared"}
+			for _, container := range undeclared {
+				name := strings.TrimPrefix(container.Names[0], "/")
+				c.Logf("will stop and remove %v because it's no longer declared", name)
+				remove.IDs = append(remove.IDs, container.ID)
+				remove.Names = append(remove.Names, name)
+				removed[container.ID] = true
+			}
+			remoteRoot = remoteRoot.Add("Remove undeclared Docker containers", remove).AsCommand()
+		}
+
+		// with gc, the stopped containers left over by interrupted deploys are removed too
+		if gc, _ := gcSettings(modules); gc && c.Rollback == nil {
+			if dangling := danglingContainers(remoteState, records); len(dangling) > 0 {
+				remove := &removeContainersCommand{Reason: "left over by an interrupted deploy"}
+				for _, container := range dangling {
+					name := strings.TrimPrefix(container.Names[0], "/")
+					c.Logf("will remove %v because it's left over by an interrupted deploy", name)
+					remove.IDs = append(remove.IDs, container.ID)
+					remove.Names = append(remove.Names, name)
+					removed[container.ID] = true
+				}
+				remoteRoot = remoteRoot.Add("Remove stopped Docker containers", remove).AsCommand()
+			}
+		}
+
 		// figure out which images needs to be uploaded to remote system
-		if len(modules) == 0 {
+		if len(records) == 0 {
 			return nil
 		}
 
 		// install docker if it's not installed.
-		remoteRoot := c.RemoteCommands.AsCommand()
 		if !remoteState.Installed {
 			remoteRoot = remoteRoot.Add("Install Docker", &installDockerCommand{}).AsCommand()
 		}
 
+		// create the managed networks before starting the containers that use them
+		createNetworks, removeNetworks := managedNetworks(records, remoteState, removed)
+		if len(createNetworks) > 0 {
+			remoteRoot = remoteRoot.Add("Create Docker networks", &createNetworksCommand{Names: createNetworks}).AsCommand()
+		}
+
 		// list local images
 		client, err := getClient()
 		var localImages []types.ImageSummary
@@ -165,63 +290,64 @@ var Manager = schema.ModuleManager{
 			remoteImageMap[img.ID] = img
 		}
 
-		// figure out which images to push to registry
-		rootPushCommand := &startDockerRegistryAndSSHTunnelCommand{connection: c.RemoteConnection}
-		pushCommands := make(map[string]*commandtree.Command) // tag -> push command.
-		containerNames := make(map[string]bool)
-		for _, module := range modules {
-			// find the tag for the image
-			tag, err := module.Image.Render(nil)
-			if err != nil {
-				return err
-			}
-			containerName, err := module.Name.Render(nil)
-			if err != nil {
-				return err
-			}
-			folder, err := module.Folder.Render(nil)
-			if err != nil {
-				return err
-			}
-			if folder != "" {
-				tag = filepath.Base(folder) + ":latest"
-			}
-			cron, err := module.Cron.Render(nil)
-			if err != nil {
-				return err
-			}
-			cronUser, err := module.CronUser.Render(nil)
-			if err != nil {
-				return err
+		// the layers the server has, so only the missing ones are sent. With the containerd
+		// image store 'docker load' needs every layer, so the whole image is sent.
+		remoteLayers := make(map[string]bool)
+		if !remoteState.Containerd {
+			for _, layer := range remoteState.Layers {
+				remoteLayers[layer] = true
 			}
+		}
+
+		// figure out which images to send to the server
+		sendCommands := make(map[string]*sendImageCommand) // image id -> send command.
+		containerNames := make(map[string]bool)
+		for _, record := range records {
+			tag := record.Tag
+			containerName := record.Name
+			cron := record.Cron
+			cronUser := record.CronUser
+			command := record.Command
+			options := record.Options
 
 			// find the corresponding image currently on the local machine.
 			var localImage types.ImageSummary
 			found := false
-			for _, image := range localImages {
-				for _, imageTag := range image.RepoTags {
-					if imageTag == tag {
-						localImage = image
-						found = true
-						break
-					}
+			if c.Rollback != nil {
+				localImage, found = localImageMap[record.ImageID]
+				if _, inRemote := remoteImageMap[record.ImageID]; !found && !inRemote {
+					return fmt.Errorf("The image %v (%v) used by the deployment is no longer available on this machine or on the server", record.ImageID, tag)
 				}
-			}
-			if !found {
-				list := make([]string, 0)
+				localImage.ID = record.ImageID
+			} else {
 				for _, image := range localImages {
 					for _, imageTag := range image.RepoTags {
-						if imageTag != "<none>:<none>" {
-							list = append(list, imageTag)
+						if imageTag == tag {
+							localImage = image
+							found = true
+							break
 						}
 					}
 				}
-				return fmt.Errorf("Could not locate image tag '%v' on this machine. Are you sure it's built? Images found: %v", tag, list)
+				if !found {
+					list := make([]string, 0)
+					for _, image := range localImages {
+						for _, imageTag := range image.RepoTags {
+							if imageTag != "<none>:<none>" {
+								list = append(list, imageTag)
+							}
+						}
+					}
+					return fmt.Errorf("Could not locate image tag '%v' on this machine. Are you sure it's built? Images found: %v", tag, list)
+				}
 			}
+			deployed := *record
+			deployed.ImageID = localImage.ID
+			c.Record.Containers = append(c.Record.Containers, &deployed)
 
 			// mark usage of image
 			l := localImage
-			for true {
+			for found {
 				localImageUsage[l.ID] = true
 
 				if l.ParentID != "" {
@@ -235,32 +361,33 @@ var Manager = schema.ModuleManager{
 				}
 			}
 
-			// check if we need to push it to registry.
+			// check if we need to send it to the server.
 			_, alreadyInRemote := remoteImageMap[localImage.ID]
-			_, markedForPush := pushCommands[tag]
-			if !alreadyInRemote && !markedForPush {
-				pushCommands[tag] = rootPushCommand.AsCommand().Add("Push "+tag, &dockerTagPushCommand{
-					client:  client,
-					imageID: localImage.ID,
-					tag:     tag,
-				}).AsCommand()
-				if len(pushCommands) == 1 {
-					c.LocalCommands.Add("Push Docker Images", rootPushCommand)
+			loadFile := ""
+			if !alreadyInRemote {
+				send, markedForSend := sendCommands[localImage.ID]
+				if !markedForSend {
+					inspect, _, err := client.ImageInspectWithRaw(context.Background(), localImage.ID)
+					if err != nil {
+						return fmt.Errorf("Could not inspect local image %v (%v): %v", tag, localImage.ID, err)
+					}
+					send = &sendImageCommand{
+						connection:   c.RemoteConnection,
+						imageID:      localImage.ID,
+						tag:          tag,
+						diffIDs:      inspect.RootFS.Layers,
+						remoteLayers: remoteLayers,
+						RemotePath:   remoteImagePath(localImage.ID),
+					}
+					sendCommands[localImage.ID] = send
+					c.LocalCommands.Add("Send "+tag, send)
 				}
+				loadFile = send.RemotePath
 			}
 
-			// figure out config for container
-			command, err := module.Command.Render(nil)
+			spec, err := newContainerSpec(record, localImage.ID)
 			if err != nil {
-				return err
-			}
-			options := make([]string, 0, len(module.Options))
-			for _, t := range module.Options {
-				opt, err := t.Render(nil)
-				if err != nil {
-					return err
-				}
-				options = append(options, opt)
+				return fmt.Errorf("container %v: %v", tag, err)
 			}
 
 			if cron != "" {
@@ -269,16 +396,20 @@ var Manager = schema.ModuleManager{
 				}
 
 				// Ensure the image required for the cron job is avaliable on the remote
-				pullTag := ""
-				if !alreadyInRemote {
-					pullTag = fmt.Sprintf("127.0.0.1:%v/%v", registryPort, tag)
-				}
-				if pullTag != "" {
-					remoteRoot.Add("Docker Image: "+pullTag, &containerCommand{
-						PullTag: pullTag,
+				if loadFile != "" {
+					remoteRoot.Add("Docker Image: "+tag, &containerCommand{
+						LoadFile: loadFile,
 					})
 				}
 
+				if record.HealthCheck != nil {
+					return fmt.Errorf("Containers running under Cron can't have a healthcheck")
+				}
+
+				if record.Restart != "" {
+					return fmt.Errorf("Containers running under Cron can't have a restart policy")
+				}
+
 				if cronUser == "" {
 					cronUser = "root"
 				}
@@ -289,25 +420,10 @@ var Manager = schema.ModuleManager{
 				cmd.WriteString("\t")
 				cmd.WriteString(cronUser)
 				cmd.WriteString("\t")
-				cmd.WriteString("docker run")
-				cmd.WriteString(" --rm")
-				for _, opt := range options {
-					cmd.WriteString(" ")
-					cmd.WriteString(opt)
-				}
-				cmd.WriteString(" " + localImage.ID)
-				cmd.WriteString(" " + command)
+				cmd.WriteString(spec.runCommand([]string{"--rm"}, options, command))
 				cronCommands = append(cronCommands, cmd.String())
 			} else {
-				// build the id.
-				h := sha1.New()
-				h.Write([]byte(command))
-				h.Write([]byte(localImage.ID))
-				h.Write([]byte(folder))
-				for _, option := range options {
-					h.Write([]byte(option))
-				}
-				containerVersion := fmt.Sprintf("%x", h.Sum(nil))
+				containerVersion := containerVersion(record, localImage.ID)
 
 				// find the name for the container
 				if containerName == "" {
@@ -340,47 +456,45 @@ var Manager = schema.ModuleManager{
 				}
 
 				if startContainer {
-					pullTag := ""
-					if !alreadyInRemote {
-						pullTag = fmt.Sprintf("127.0.0.1:%v/%v", registryPort, tag)
+					spec.Labels["dogo"] = containerVersion
+					for key, value := range gitLabels(c.Git) {
+						spec.Labels[key] = value
 					}
 
-					cmd := bytes.NewBuffer(nil)
-					cmd.WriteString("docker run")
-					cmd.WriteString(" --detach")
-					cmd.WriteString(" --label dogo=" + containerVersion)
-					cmd.WriteString(" --name " + containerName)
-					for _, opt := range options {
-						cmd.WriteString(" ")
-						cmd.WriteString(opt)
-					}
-					cmd.WriteString(" " + localImage.ID)
-					cmd.WriteString(" " + command)
-					remoteRoot.Add("Docker Container: "+containerName, &containerCommand{
-						PullTag:         pullTag,
+					// containers with raw options can only be started with 'docker run'
+					start := &containerCommand{
+						LoadFile:        loadFile,
 						StopContainerID: stopID,
-						StartCommand:    cmd.String(),
-					})
+						Name:            containerName,
+						HealthCheck:     record.HealthCheck,
+					}
+					if len(options) > 0 {
+						start.StartCommand = spec.runCommand([]string{"--detach", "--name", containerName}, options, command)
+					} else {
+						start.Spec = spec
+					}
+					remoteRoot.Add("Docker Container: "+containerName, start)
 				}
 			}
 		}
 
-		// send command to remove unused images if any
-		for _, img := range remoteState.Images {
-			if used, found := localImageUsage[img.ID]; !found || !used {
-				usedInContainer := false
-				for _, container := range remoteState.Containers {
-					if container.ImageID == img.ID {
-						usedInContainer = true
-						break
-					}
-				}
-				if !usedInContainer {
-					//remoteRoot.Add("Remove unused image: "+img.ID, &removeImagesCommand{Image: img.ID})
-				}
+		// remove unused images, including the ones of the undeclared containers
+		if gc, keepLast := gcSettings(modules); gc && c.Rollback == nil {
+			if images := garbage(remoteState, removed, localImageUsage, keepLast); len(images) > 0 {
+				remoteRoot.Add("Remove unused Docker images", &removeImagesCommand{Images: images})
 			}
 		}
 
+		// image archives that were sent, but never loaded
+		if len(remoteState.ImageFiles) > 0 {
+			remoteRoot.Add("Remove stale Docker image archives", &removeImageFilesCommand{Paths: remoteState.ImageFiles})
+		}
+
+		// networks no container uses anymore
+		if len(removeNetworks) > 0 {
+			remoteRoot.Add("Remove unused Docker networks", &removeNetworksCommand{Names: removeNetworks})
+		}
+
 		// cron commands
 		buf := bytes.NewBuffer(nil)
 		for _, command := range cronCommands {
@@ -400,11 +514,169 @@ var Manager = schema.ModuleManager{
 	},
 }
 
+// containerVersion is the 'dogo' label of the container. A container is replaced
+// when its version changes.
+func containerVersion(record *schema.ContainerRecord, imageID string) string {
+	h := sha1.New()
+	h.Write([]byte(record.Command))
+	h.Write([]byte(imageID))
+	h.Write([]byte(record.Folder))
+	for _, option := range record.Options {
+		h.Write([]byte(option))
+	}
+
+	// the structured settings are only part of the version when they're used,
+	// so containers deployed before they existed keep their version.
+	for _, alias := range record.Aliases {
+		h.Write([]byte("\x00alias=" + alias))
+	}
+	for _, setting := range [][2]string{{"entrypoint", record.Entrypoint}, {"restart", record.Restart}, {"network", record.Network}, {"memory", record.Memory}, {"cpus", record.CPUs}, {"user", record.User}} {
+		if setting[1] != "" {
+			h.Write([]byte("\x00" + setting[0] + "=" + setting[1]))
+		}
+	}
+	for _, port := range record.Ports {
+		h.Write([]byte("\x00port=" + port))
+	}
+	for _, volume := range record.Volumes {
+		h.Write([]byte("\x00volume=" + volume))
+	}
+	for _, key := range sortedKeys(record.Env) {
+		h.Write([]byte("\x00env." + key + "=" + record.Env[key]))
+	}
+	for _, key := range sortedKeys(record.Labels) {
+		h.Write([]byte("\x00label." + key + "=" + record.Labels[key]))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// gitLabels returns the labels with the git state of the deployed project
+func gitLabels(git *schema.GitInfo) map[string]string {
+	if git == nil {
+		return nil
+	}
+	labels := map[string]string{
+		labelGitCommit: git.Commit,
+		labelGitDirty:  strconv.FormatBool(git.Dirty),
+	}
+	if git.Branch != "" {
+		labels[labelGitBranch] = git.Branch
+	}
+	return labels
+}
+
+// renderContainer renders the templates of the module
+func renderContainer(module *Docker) (*schema.ContainerRecord, error) {
+	record := &schema.ContainerRecord{}
+
+	// find the tag for the image
+	var err error
+	if record.Tag, err = module.Image.Render(nil); err != nil {
+		return nil, err
+	}
+	if record.Name, err = module.Name.Render(nil); err != nil {
+		return nil, err
+	}
+	if record.Folder, err = module.Folder.Render(nil); err != nil {
+		return nil, err
+	}
+	if record.Folder != "" {
+		record.Tag = filepath.Base(record.Folder) + ":latest"
+	}
+	if record.Cron, err = module.Cron.Render(nil); err != nil {
+		return nil, err
+	}
+	if record.CronUser, err = module.CronUser.Render(nil); err != nil {
+		return nil, err
+	}
+
+	// figure out config for container
+	if record.Command, err = module.Command.Render(nil); err != nil {
+		return nil, err
+	}
+	record.Options = make([]string, 0, len(module.Options))
+	for _, t := range module.Options {
+		opt, err := t.Render(nil)
+		if err != nil {
+			return nil, err
+		}
+		record.Options = append(record.Options, opt)
+	}
+	if record.Entrypoint, err = module.Entrypoint.Render(nil); err != nil {
+		return nil, err
+	}
+	if record.Network, err = module.Network.Render(nil); err != nil {
+		return nil, err
+	}
+	if record.User, err = module.User.Render(nil); err != nil {
+		return nil, err
+	}
+	record.Ports = module.Ports
+	record.Restart = module.Restart
+	record.Memory = module.Memory
+	record.CPUs = module.Cpus
+	for _, t := range module.Aliases {
+		alias, err := t.Render(nil)
+		if err != nil {
+			return nil, err
+		}
+		record.Aliases = append(record.Aliases, alias)
+	}
+	for _, t := range module.Volumes {
+		volume, err := t.Render(nil)
+		if err != nil {
+			return nil, err
+		}
+		record.Volumes = append(record.Volumes, volume)
+	}
+	if record.Env, err = renderMap(module.Env); err != nil {
+		return nil, err
+	}
+	if record.Labels, err = renderMap(module.Labels); err != nil {
+		return nil, err
+	}
+
+	// how to check that it started
+	if module.HealthCheck != nil {
+		if record.HealthCheck, err = module.HealthCheck.render(); err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
+
+func renderMap(templates map[string]schema.Template) (map[string]string, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for key, t := range templates {
+		value, err := t.Render(nil)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
 type writeCronCommand struct {
 	commandtree.Command
 	Content []byte
 }
 
+func (c *writeCronCommand) Describe() []string {
+	if len(c.Content) == 0 {
+		return []string{"delete " + cronFile}
+	}
+	lines := []string{"write " + cronFile + ":"}
+	for _, line := range strings.Split(strings.TrimRight(string(c.Content), "\n"), "\n") {
+		lines = append(lines, "  "+line)
+	}
+	return lines
+}
+
 func (c *writeCronCommand) Execute() {
 	if len(c.Content) == 0 {
 		err := os.Remove(cronFile)
@@ -454,118 +726,80 @@ func (c *installDockerCommand) Execute() {
 	c.Logf("docker installed.")
 }
 
-type startDockerRegistryAndSSHTunnelCommand struct {
+type containerCommand struct {
 	commandtree.Command
-	connection schema.ServerConnection
+	LoadFile        string // image archive to load (and remove). If "", the image is on the server
+	StopContainerID string
+	StartCommand    string         // 'docker run' command line, for containers with raw options
+	Spec            *containerSpec // created with the docker API, if there's no StartCommand
+	Name            string
+	HealthCheck     *schema.HealthCheck // if set, wait for the container to pass it after starting
 }
 
-func (c *startDockerRegistryAndSSHTunnelCommand) Execute() {
-	// Ensure the DckerRegistry is started.
-	err := StartDockerRegistry("error")
-	if err != nil {
-		c.Errf("Couldn't start local docker registry: %v", err)
-		return
+func (c *containerCommand) Describe() []string {
+	lines := make([]string, 0, 3)
+	if c.LoadFile != "" {
+		lines = append(lines, "docker load --input "+c.LoadFile)
 	}
-
-	// ensure the SSH Tunnel is started
-	_, err = c.connection.StartTunnel(registryPort, registryPort, "", true)
-	if err != nil {
-		c.Errf("Couldn't create tunnel from remote machine to local docker registry: %v", err)
-		return
+	if c.StopContainerID != "" {
+		lines = append(lines, "stop container "+c.StopContainerID+" and rename it to "+c.Name+previousContainerSuffix)
 	}
-}
-
-type dockerTagPushCommand struct {
-	commandtree.Command
-	tag     string
-	client  *client.Client
-	imageID string
-}
-
-var pushLock = sync.RWMutex{}
-var pushMap = make(map[string]*sync.Once)
-
-func (c *dockerTagPushCommand) Execute() {
-	pushTag := fmt.Sprintf("%v/%v", registryAddr, c.tag)
-
-	// In inprocess cache to ensure that if deploying to
-	// 20 servers, the local push only happens once.
-	pushLock.Lock()
-	o, found := pushMap[pushTag]
-	if !found {
-		o = &sync.Once{}
-		pushMap[pushTag] = o
+	if c.StartCommand != "" {
+		lines = append(lines, c.StartCommand)
 	}
-	pushLock.Unlock()
-
-	o.Do(func() {
-		// tag image.
-		c.Logf("docker tag %v %v", c.imageID, pushTag)
-		err := commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "tag", c.imageID, pushTag)
-		if err != nil {
-			c.Errf(err.Error())
-			return
-		}
-
-		// push image.
-		c.Logf("docker push %v", pushTag)
-		err = commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "push", pushTag)
-		if err != nil {
-			if len(c.LogArray) > 0 {
-				last := c.LogArray[len(c.LogArray)-1]
-				if last.Error != nil && strings.Contains(last.Error.Error(), "HTTPS") {
-					err = fmt.Errorf("It seems the docker deamon is trying to talk HTTPS to %v, when it should be talking HTTP. Did you add %v as an insecure-registry? (full err: %v)", registryAddr, registryAddr, err)
-				}
-			}
-			c.Errf(err.Error())
-			return
-		}
-	})
+	if c.Spec != nil {
+		lines = append(lines, "create container (docker api): "+c.Spec.runCommand([]string{"--detach", "--name", c.Name}, nil, quoteWords(c.Spec.Cmd)))
+	}
+	if (c.StartCommand != "" || c.Spec != nil) && c.HealthCheck != nil {
+		lines = append(lines, describeHealthCheck(c.HealthCheck))
+	}
+	if c.StopContainerID != "" {
+		lines = append(lines, "remove "+c.Name+previousContainerSuffix+" (or restore it if the new container fails)")
+	}
+	return lines
 }
 
-type containerCommand struct {
-	commandtree.Command
-	PullTag         string // tag to pull, if "", don't pull
-	StopContainerID string
-	StartCommand    string
+type loadedImage struct {
+	once sync.Once
+	err  error
 }
 
-var pullOnceMap = make(map[string]*sync.Mutex)
-var pullOnceMapMutex sync.Mutex
+var loadOnceMap = make(map[string]*loadedImage)
+var loadOnceMapMutex sync.Mutex
 
 func (c *containerCommand) Execute() {
-	// pull the tag.
-	if c.PullTag != "" {
-		pullOnceMapMutex.Lock()
-		pull := false
-		m, found := pullOnceMap[c.PullTag]
+	// load the image. Containers using the same image share the archive, so it's loaded once.
+	if c.LoadFile != "" {
+		loadOnceMapMutex.Lock()
+		l, found := loadOnceMap[c.LoadFile]
 		if !found {
-			m = &sync.Mutex{}
-			pull = true
-			pullOnceMap[c.PullTag] = m
-		}
-		pullOnceMapMutex.Unlock()
-		m.Lock()
-		if pull {
-			c.Logf("Pulling docker image")
-			err := commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "pull", c.PullTag)
-			if err != nil {
-				c.Errf(err.Error())
-				return
-			}
+			l = &loadedImage{}
+			loadOnceMap[c.LoadFile] = l
+		}
+		loadOnceMapMutex.Unlock()
+		l.once.Do(func() {
+			c.Logf("Loading docker image")
+			l.err = commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "load", "--input", c.LoadFile)
+			os.Remove(c.LoadFile)
+		})
+		if l.err != nil {
+			c.Errf("Could not load docker image: %v", l.err)
+			return
 		}
-		m.Unlock()
 	}
 
-	// stop existing container (just force it)
+	// stop the existing container, and keep it (renamed) until the new one is running.
+	previousName := ""
 	if c.StopContainerID != "" {
-		c.Logf("Stopping/Removing existing container")
+		previousName = c.Name + previousContainerSuffix
+		c.Logf("Stopping existing container (keeping it as %v until the new container is running)", previousName)
+		exec.Command("docker", "rm", "--force", previousName).Run() // left over from an earlier failed deploy, if any.
 		err := commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "stop", c.StopContainerID)
 		if err != nil {
 			c.Errf(err.Error())
 			return
 		}
-		err = commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "rm", c.StopContainerID)
+		err = commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "rename", c.StopContainerID, previousName)
 		if err != nil {
 			c.Errf(err.Error())
 			return
@@ -573,9 +807,35 @@ func (c *containerCommand) Execute() {
 	}
 
 	// start the new one.
-	if c.StartCommand != "" {
-		c.Logf("Starting container: " + c.StartCommand)
-		err := commandtree.OSExec(c.AsCommand(), "", " - ", "/bin/bash", "-c", c.StartCommand)
+	if c.StartCommand != "" || c.Spec != nil {
+		var err error
+		if c.Spec != nil {
+			c.Logf("Creating and starting container")
+			err = c.createAndStart()
+		} else {
+			c.Logf("Starting container: " + c.StartCommand)
+			err = commandtree.OSExec(c.AsCommand(), "", " - ", "/bin/bash", "-c", c.StartCommand)
+		}
+
+		// wait for it to be healthy
+		if err == nil && c.HealthCheck != nil {
+			c.Logf("Waiting for container to pass health check")
+			err = waitHealthy(c.AsCommand(), c.Name, c.HealthCheck)
+		}
+
+		if err != nil {
+			c.Errf("New container failed: %v", err)
+			if previousName != "" {
+				c.restorePrevious(previousName)
+			}
+			return
+		}
+	}
+
+	// the new container is running, so the previous one can go.
+	if previousName != "" {
+		c.Logf("Removing previous container")
+		err := commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "rm", previousName)
 		if err != nil {
 			c.Errf(err.Error())
 			return
@@ -583,17 +843,48 @@ func (c *containerCommand) Execute() {
 	}
 }
 
-type removeImagesCommand struct {
-	commandtree.Command
-	Image string
+// createAndStart creates the container from the spec with the docker API, and starts it
+func (c *containerCommand) createAndStart() error {
+	config, hostConfig, networkingConfig, err := c.Spec.config()
+	if err != nil {
+		return err
+	}
+	client, err := getClient()
+	if err != nil {
+		return fmt.Errorf("Could not get docker client: %v", err)
+	}
+	defer client.Close()
+
+	created, err := client.ContainerCreate(context.Background(), config, hostConfig, networkingConfig, nil, c.Name)
+	if err != nil {
+		return fmt.Errorf("Could not create container: %v", err)
+	}
+	for _, warning := range created.Warnings {
+		c.Logf(" - warning: %v", warning)
+	}
+	if err := client.ContainerStart(context.Background(), created.ID, types.ContainerStartOptions{}); err != nil {
+		client.ContainerRemove(context.Background(), created.ID, types.ContainerRemoveOptions{Force: true})
+		return fmt.Errorf("Could not start container: %v", err)
+	}
+	return nil
 }
 
-func (c *removeImagesCommand) Execute() {
-	err := commandtree.OSExec(c.AsCommand(), "", "", "docker", "rmi", c.Image)
+// restorePrevious replaces the failed new container with the previous one
+func (c *containerCommand) restorePrevious(previousName string) {
+	c.Logf("Removing failed container and restoring previous container")
+	exec.Command("docker", "rm", "--force", c.Name).Run()
+
+	err := commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "rename", previousName, c.Name)
+	if err != nil {
+		c.Errf("Could not restore previous container, it's still available as %v: %v", previousName, err)
+		return
+	}
+	err = commandtree.OSExec(c.AsCommand(), "", " - ", "docker", "start", c.Name)
 	if err != nil {
-		c.Errf(err.Error())
+		c.Errf("Restored previous container, but could not start it: %v", err)
 		return
 	}
+	c.Logf("Previous container restored and running")
 }
 
 func getClient() (*client.Client, error) {
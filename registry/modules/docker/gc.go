@@ -0,0 +1,242 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// deployedImages are the images used by a deployment recorded on the server (see schema.DeploymentsPath)
+type deployedImages struct {
+	Environment string
+	Number      int
+	Images      []string
+}
+
+// readDeployedImages reads the images used by the deployment records stored in dir
+func readDeployedImages(dir string) ([]deployedImages, error) {
+	environments, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result := make([]deployedImages, 0)
+	for _, env := range environments {
+		if !env.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(dir, env.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			number, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".json"))
+			if err != nil || !strings.HasSuffix(f.Name(), ".json") {
+				continue
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, env.Name(), f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			record := struct {
+				Servers map[string]*schema.DeploymentRecord `json:"servers"`
+			}{}
+			if err := json.Unmarshal(b, &record); err != nil {
+				return nil, err
+			}
+
+			// the record has every server in the environment, and the images of all are kept.
+			d := deployedImages{Environment: env.Name(), Number: number}
+			for _, server := range record.Servers {
+				for _, container := range server.Containers {
+					d.Images = append(d.Images, container.ImageID)
+				}
+			}
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// gcSettings returns if garbage collection is enabled for the server, and how
+// many deployments to keep the images of. It's enabled if any of the containers
+// on the server enable it, and the highest keep_last is used.
+func gcSettings(modules []*Docker) (bool, int) {
+	enabled, keepLast := false, 0
+	for _, module := range modules {
+		if module.GC {
+			enabled = true
+			if module.KeepLast > keepLast {
+				keepLast = module.KeepLast
+			}
+		}
+	}
+	return enabled, keepLast
+}
+
+// danglingContainers finds the stopped previous containers (see previousContainerSuffix)
+// left over by interrupted deploys. They're only dangling once the container that
+// replaced them is running again, until then they're kept so they can be restored by hand.
+func danglingContainers(remoteState *state, records []*schema.ContainerRecord) []types.Container {
+	running := make(map[string]bool)
+	for _, container := range remoteState.Containers {
+		if container.State == "running" {
+			for _, name := range container.Names {
+				running[name] = true
+			}
+		}
+	}
+
+	result := make([]types.Container, 0)
+	for _, container := range remoteState.Containers {
+		if _, byDogo := container.Labels["dogo"]; !byDogo || container.State == "running" || container.State == "restarting" || container.State == "paused" {
+			continue
+		}
+		for _, record := range records {
+			if record.Name != "" && running["/"+record.Name] && hasName(container, "/"+record.Name+previousContainerSuffix) {
+				result = append(result, container)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func hasName(container types.Container, name string) bool {
+	for _, n := range container.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// garbage finds the images to remove from the server. Images are never removed if
+// they're in keep (the images being deployed), used by a container that isn't in
+// removed (container ids), or used by the last keepLast deployments recorded on the
+// server (so they can be rolled back to).
+func garbage(remoteState *state, removed map[string]bool, keep map[string]bool, keepLast int) (images []string) {
+	protected := make(map[string]bool)
+	for id, used := range keep {
+		if used {
+			protected[id] = true
+		}
+	}
+	for _, container := range remoteState.Containers {
+		if !removed[container.ID] {
+			protected[container.ImageID] = true
+		}
+	}
+
+	// images of the last deployments of each environment
+	deployments := append([]deployedImages{}, remoteState.Deployments...)
+	sort.SliceStable(deployments, func(i, j int) bool { return deployments[i].Number > deployments[j].Number })
+	kept := make(map[string]int)
+	for _, d := range deployments {
+		if kept[d.Environment] >= keepLast {
+			continue
+		}
+		kept[d.Environment]++
+		for _, id := range d.Images {
+			protected[id] = true
+		}
+	}
+
+	// the parents of the protected images are protected too. Only images that aren't
+	// parents of other images are removed (their untagged parents are removed with them).
+	parents := make(map[string]string)
+	isParent := make(map[string]bool)
+	for _, img := range remoteState.Images {
+		parents[img.ID] = img.ParentID
+		isParent[img.ParentID] = true
+	}
+	for id := range protected {
+		for parent := parents[id]; parent != "" && !protected[parent]; parent = parents[parent] {
+			protected[parent] = true
+		}
+	}
+	for _, img := range remoteState.Images {
+		if !protected[img.ID] && !isParent[img.ID] {
+			images = append(images, img.ID)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// staleImageFiles finds the image archives matching pattern (see remoteImagePath) left by
+// deploys that stopped before they were loaded. Archives are only stale after a day,
+// since the servers of rolling deploys load them long after they're sent.
+func staleImageFiles(pattern string, now time.Time) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	stale := make([]string, 0)
+	for _, path := range matches {
+		if stat, err := os.Stat(path); err == nil && now.Sub(stat.ModTime()) > staleImageFileAge {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
+
+// staleImageFileAge is how old image archives on servers must be before they're removed
+const staleImageFileAge = time.Hour * 24
+
+type removeImageFilesCommand struct {
+	commandtree.Command
+	Paths []string
+}
+
+func (c *removeImageFilesCommand) Describe() []string {
+	return []string{"remove image archives that were never loaded: " + strings.Join(c.Paths, ", ")}
+}
+
+func (c *removeImageFilesCommand) Execute() {
+	for _, path := range c.Paths {
+		c.Logf("Removing %v", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			c.Errf("Could not remove %v: %v", path, err)
+		}
+	}
+}
+
+type removeImagesCommand struct {
+	commandtree.Command
+	Images []string
+}
+
+func (c *removeImagesCommand) Describe() []string {
+	return []string{"remove unused images: " + strings.Join(c.Images, ", ")}
+}
+
+func (c *removeImagesCommand) Execute() {
+	client, err := getClient()
+	if err != nil {
+		c.Errf("Could not get docker client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	for _, id := range c.Images {
+		c.Logf("Removing image %v", id)
+		// force removes all the tags of the image. It's never used by a container, so nothing else is forced.
+		if _, err := client.ImageRemove(context.Background(), id, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
+			c.Errf("Could not remove image %v: %v", id, err)
+		}
+	}
+}
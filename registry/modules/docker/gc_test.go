@@ -0,0 +1,122 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestReadDeployedImages(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "prod"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "prod", "3.json"), []byte(`{"number":3,"servers":{"web1":{"containers":[{"image_id":"sha256:a"}]},"web2":{"containers":[{"image_id":"sha256:b"}]}}}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "prod", "notes.txt"), []byte(`not a record`), 0600)
+
+	deployments, err := readDeployedImages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].Environment != "prod" || deployments[0].Number != 3 || len(deployments[0].Images) != 2 {
+		t.Errorf("unexpected deployments: %+v", deployments)
+	}
+
+	if deployments, err := readDeployedImages(filepath.Join(dir, "missing")); err != nil || len(deployments) != 0 {
+		t.Errorf("expected nothing from a missing dir, got %v %v", deployments, err)
+	}
+}
+
+func TestGCSettings(t *testing.T) {
+	if enabled, _ := gcSettings([]*Docker{{KeepLast: 2}}); enabled {
+		t.Errorf("expected gc to be off by default")
+	}
+	if enabled, keepLast := gcSettings([]*Docker{{KeepLast: 5}, {GC: true, KeepLast: 2}, {GC: true, KeepLast: 3}}); !enabled || keepLast != 3 {
+		t.Errorf("expected gc with the highest keep_last of the containers using it, got %v %v", enabled, keepLast)
+	}
+}
+
+func TestGarbage(t *testing.T) {
+	remoteState := &state{
+		Containers: []types.Container{
+			{ID: "1", ImageID: "web-old"}, // replaced by the deploy
+			{ID: "2", ImageID: "worker"},
+			{ID: "3", ImageID: "old"}, // removed by the deploy
+		},
+		Images: []types.ImageSummary{
+			{ID: "web-new", ParentID: "base"}, {ID: "base"},
+			{ID: "web-old"}, {ID: "worker"}, {ID: "old"},
+			{ID: "deployed-1"}, {ID: "deployed-2"}, {ID: "deployed-3"}, {ID: "staging"},
+			{ID: "unused", ParentID: "unused-parent"}, {ID: "unused-parent"},
+			{ID: "child", ParentID: "deployed-parent"}, {ID: "deployed-parent", ParentID: "deployed-grandparent"}, {ID: "deployed-grandparent"},
+		},
+		Deployments: []deployedImages{
+			{Environment: "prod", Number: 1, Images: []string{"deployed-1"}},
+			{Environment: "prod", Number: 3, Images: []string{"deployed-3", "deployed-parent"}},
+			{Environment: "prod", Number: 2, Images: []string{"deployed-2"}},
+			{Environment: "staging", Number: 7, Images: []string{"staging"}},
+		},
+	}
+	removed := map[string]bool{"3": true}
+	keep := map[string]bool{"web-new": true, "base": true, "other-local": false}
+
+	if images := garbage(remoteState, removed, keep, 2); strings.Join(images, ",") != "child,deployed-1,old,unused" {
+		t.Errorf("unexpected images to remove: %v", images)
+	}
+
+	// with keep_last = 0 only the images in use are kept
+	if images := garbage(remoteState, removed, keep, 0); strings.Join(images, ",") != "child,deployed-1,deployed-2,deployed-3,old,staging,unused" {
+		t.Errorf("unexpected images to remove with keep_last = 0: %v", images)
+	}
+}
+
+func TestDanglingContainers(t *testing.T) {
+	dogo := map[string]string{"dogo": "version"}
+	remoteState := &state{
+		Containers: []types.Container{
+			{ID: "1", Names: []string{"/web"}, State: "running", Labels: dogo},
+			{ID: "2", Names: []string{"/web_dogo_previous"}, State: "exited", Labels: dogo}, // web is running again
+			{ID: "3", Names: []string{"/api"}, State: "exited", Labels: dogo},
+			{ID: "4", Names: []string{"/api_dogo_previous"}, State: "exited", Labels: dogo}, // might have to be restored
+			{ID: "5", Names: []string{"/db_dogo_previous"}, State: "exited"},                // not started by dogo
+			{ID: "6", Names: []string{"/db"}, State: "running"},
+		},
+	}
+
+	dangling := danglingContainers(remoteState, []*schema.ContainerRecord{{Name: "web"}, {Name: "api"}, {Name: "db"}})
+	if len(dangling) != 1 || dangling[0].ID != "2" {
+		t.Errorf("expected only the previous container of web to be dangling, got %v", dangling)
+	}
+}
+
+func TestStaleImageFiles(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "dogo-image-*.tar")
+	old, recent := filepath.Join(dir, "dogo-image-old.tar"), filepath.Join(dir, "dogo-image-recent.tar")
+	for _, path := range []string{old, recent, filepath.Join(dir, "other.tar")} {
+		ioutil.WriteFile(path, []byte("archive"), 0600)
+	}
+	now := time.Now()
+	os.Chtimes(old, now.Add(-staleImageFileAge*2), now.Add(-staleImageFileAge*2))
+
+	stale, err := staleImageFiles(pattern, now)
+	if err != nil || strings.Join(stale, ",") != old {
+		t.Fatalf("expected only the old archive to be stale, got %v %v", stale, err)
+	}
+
+	remove := &removeImageFilesCommand{Paths: append(stale, filepath.Join(dir, "dogo-image-gone.tar"))}
+	remove.Execute()
+	if remove.AnyError() {
+		t.Errorf("expected no errors, got %v", remove.LogArray)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old archive to be removed")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("expected the recent archive to be kept")
+	}
+}
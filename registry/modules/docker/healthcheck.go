@@ -0,0 +1,152 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// HealthCheck is checked after a container is started. The deploy of the
+// container fails if the check doesn't pass.
+type HealthCheck struct {
+	URL      schema.Template `description:"An http(s) url, as seen from the server, that must respond with a status below 400. E.g. 'http://127.0.0.1:8080/health'"`
+	Port     int             `description:"A tcp port on the server that must accept connections"`
+	Command  schema.Template `description:"A command run inside the container (with docker exec) that must exit with status 0"`
+	Timeout  string          `default:"5s" description:"How long each check may take"`
+	Interval string          `default:"2s" description:"How long to wait before each check"`
+	Retries  int             `default:"15" description:"How many times to check before giving up"`
+}
+
+// Validate checks the durations and retries
+func (h *HealthCheck) Validate() error {
+	for name, value := range map[string]string{"timeout": h.Timeout, "interval": h.Interval} {
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("healthcheck %v must be a duration such as '5s'. Got: %v", name, value)
+		}
+	}
+	if h.Retries < 1 {
+		return fmt.Errorf("healthcheck retries must be at least 1. Got: %v", h.Retries)
+	}
+	return nil
+}
+
+// render renders the templates of the health check
+func (h *HealthCheck) render() (*schema.HealthCheck, error) {
+	url, err := h.URL.Render(nil)
+	if err != nil {
+		return nil, err
+	}
+	command, err := h.Command.Render(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for _, set := range []bool{url != "", h.Port != 0, command != ""} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, errors.New("a healthcheck must have exactly one of url, port or command")
+	}
+
+	return &schema.HealthCheck{
+		URL:      url,
+		Port:     h.Port,
+		Command:  command,
+		Timeout:  h.Timeout,
+		Interval: h.Interval,
+		Retries:  h.Retries,
+	}, nil
+}
+
+func describeHealthCheck(h *schema.HealthCheck) string {
+	check := "command " + h.Command
+	if h.URL != "" {
+		check = "url " + h.URL
+	} else if h.Port != 0 {
+		check = "port " + strconv.Itoa(h.Port)
+	}
+	return fmt.Sprintf("wait for health check: %v (%v x %v)", check, h.Retries, h.Interval)
+}
+
+// waitHealthy runs the health check against the container until it passes. It
+// gives up when the check has failed h.Retries times, or the container stops running.
+func waitHealthy(c *commandtree.Command, name string, h *schema.HealthCheck) error {
+	timeout, _ := time.ParseDuration(h.Timeout)
+	interval, _ := time.ParseDuration(h.Interval)
+
+	var err error
+	for attempt := 1; attempt <= h.Retries; attempt++ {
+		time.Sleep(interval)
+
+		// a container that crashed (or is restarting) will never become healthy
+		state, stateErr := exec.Command("docker", "inspect", "--format", "{{.State.Status}}", name).Output()
+		if stateErr != nil {
+			return fmt.Errorf("Could not inspect container %v: %v", name, stateErr)
+		}
+		if status := strings.TrimSpace(string(state)); status != "running" {
+			logContainerOutput(c, name)
+			return fmt.Errorf("Container %v is %v, it did not pass its health check", name, status)
+		}
+
+		if err = checkHealth(name, h, timeout); err == nil {
+			c.Logf("Container passed health check")
+			return nil
+		}
+		c.Logf("Health check %v of %v failed: %v", attempt, h.Retries, err)
+	}
+
+	logContainerOutput(c, name)
+	return fmt.Errorf("Container %v did not pass its health check after %v attempts. Last error: %v", name, h.Retries, err)
+}
+
+func checkHealth(name string, h *schema.HealthCheck, timeout time.Duration) error {
+	switch {
+	case h.URL != "":
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(h.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%v responded with status %v", h.URL, resp.Status)
+		}
+		return nil
+	case h.Port != 0:
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(h.Port)), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		output, err := exec.CommandContext(ctx, "docker", "exec", name, "sh", "-c", h.Command).CombinedOutput()
+		if err != nil {
+			if out := strings.TrimSpace(string(output)); out != "" {
+				return fmt.Errorf("%v: %v", err, out)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// logContainerOutput logs the last lines written by the container, which
+// usually tells why it isn't healthy.
+func logContainerOutput(c *commandtree.Command, name string) {
+	c.Logf("Last output from container %v:", name)
+	commandtree.OSExecAllToStdOut(c, "", " - ", "docker", "logs", "--tail", "20", name)
+}
@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestCheckHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	if err := checkHealth("web", &schema.HealthCheck{URL: server.URL + "/health"}, time.Second); err != nil {
+		t.Errorf("expected healthy url, got %v", err)
+	}
+	if err := checkHealth("web", &schema.HealthCheck{URL: server.URL + "/other"}, time.Second); err == nil {
+		t.Errorf("expected 503 to be unhealthy")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := checkHealth("web", &schema.HealthCheck{Port: port}, time.Second); err != nil {
+		t.Errorf("expected open port to be healthy, got %v", err)
+	}
+	listener.Close()
+	if err := checkHealth("web", &schema.HealthCheck{Port: port}, time.Second); err == nil {
+		t.Errorf("expected closed port to be unhealthy")
+	}
+}
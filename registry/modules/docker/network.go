@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// networks with this prefix are managed by dogo: they're created when a container
+// uses them, and removed when no container uses them anymore.
+const managedNetworkPrefix = "dogo_"
+
+// labelManagedNetwork is set on the networks created by dogo. Only those are removed.
+const labelManagedNetwork = "dogo.managed"
+
+type networkState struct {
+	ID     string
+	Name   string
+	Labels map[string]string
+}
+
+func listNetworks(client *client.Client) ([]networkState, error) {
+	networks, err := client.NetworkList(context.Background(), types.NetworkListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]networkState, 0, len(networks))
+	for _, n := range networks {
+		result = append(result, networkState{ID: n.ID, Name: n.Name, Labels: n.Labels})
+	}
+	return result, nil
+}
+
+func isManagedNetwork(name string) bool {
+	return strings.HasPrefix(name, managedNetworkPrefix)
+}
+
+// managedNetworks finds the dogo managed networks to create for the containers,
+// and the ones no container uses anymore. Networks still attached to a container
+// (that isn't in removed) are kept, so a network a replaced container left is
+// removed by the next deploy.
+func managedNetworks(records []*schema.ContainerRecord, remoteState *state, removed map[string]bool) (create []string, remove []string) {
+	used := make(map[string]bool)
+	for _, record := range records {
+		if isManagedNetwork(record.Network) {
+			used[record.Network] = true
+		}
+	}
+	for _, container := range remoteState.Containers {
+		if container.NetworkSettings != nil && !removed[container.ID] {
+			for name := range container.NetworkSettings.Networks {
+				used[name] = true
+			}
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, n := range remoteState.Networks {
+		existing[n.Name] = true
+		if isManagedNetwork(n.Name) && n.Labels[labelManagedNetwork] == "true" && !used[n.Name] {
+			remove = append(remove, n.Name)
+		}
+	}
+	for name := range used {
+		if isManagedNetwork(name) && !existing[name] {
+			create = append(create, name)
+		}
+	}
+	sort.Strings(create)
+	sort.Strings(remove)
+	return create, remove
+}
+
+type createNetworksCommand struct {
+	commandtree.Command
+	Names []string
+}
+
+func (c *createNetworksCommand) Describe() []string {
+	return []string{"create docker networks: " + strings.Join(c.Names, ", ")}
+}
+
+func (c *createNetworksCommand) Execute() {
+	client, err := getClient()
+	if err != nil {
+		c.Errf("Could not get docker client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	for _, name := range c.Names {
+		c.Logf("Creating network %v", name)
+		_, err := client.NetworkCreate(context.Background(), name, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         "bridge",
+			Labels:         map[string]string{labelManagedNetwork: "true"},
+		})
+		if err != nil {
+			c.Errf("Could not create network %v: %v", name, err)
+		}
+	}
+}
+
+type removeNetworksCommand struct {
+	commandtree.Command
+	Names []string
+}
+
+func (c *removeNetworksCommand) Describe() []string {
+	return []string{"remove unused docker networks: " + strings.Join(c.Names, ", ")}
+}
+
+func (c *removeNetworksCommand) Execute() {
+	client, err := getClient()
+	if err != nil {
+		c.Errf("Could not get docker client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	for _, name := range c.Names {
+		c.Logf("Removing network %v", name)
+		if err := client.NetworkRemove(context.Background(), name); err != nil {
+			c.Errf("Could not remove network %v: %v", name, err)
+		}
+	}
+}
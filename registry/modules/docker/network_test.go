@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func attachedTo(networks ...string) types.Container {
+	settings := &types.SummaryNetworkSettings{Networks: make(map[string]*network.EndpointSettings)}
+	for _, name := range networks {
+		settings.Networks[name] = &network.EndpointSettings{}
+	}
+	return types.Container{NetworkSettings: settings}
+}
+
+func TestManagedNetworks(t *testing.T) {
+	managed := map[string]string{labelManagedNetwork: "true"}
+	remoteState := &state{
+		Containers: []types.Container{attachedTo("dogo_old"), attachedTo("bridge"), attachedTo("dogo_removed")},
+		Networks: []networkState{
+			{Name: "bridge"},
+			{Name: "dogo_web", Labels: managed},
+			{Name: "dogo_old", Labels: managed},     // still attached to a container
+			{Name: "dogo_unused", Labels: managed},  // not used by anything
+			{Name: "dogo_removed", Labels: managed}, // only attached to a container being removed
+			{Name: "dogo_mine"},                     // not created by dogo
+		},
+	}
+	records := []*schema.ContainerRecord{{Network: "dogo_web"}, {Network: "dogo_db"}, {Network: "dogo_db"}, {Network: "backend"}}
+
+	remoteState.Containers[2].ID = "removed"
+
+	create, remove := managedNetworks(records, remoteState, map[string]bool{"removed": true})
+	if strings.Join(create, ",") != "dogo_db" {
+		t.Errorf("expected dogo_db to be created, got %v", create)
+	}
+	if strings.Join(remove, ",") != "dogo_removed,dogo_unused" {
+		t.Errorf("expected dogo_removed and dogo_unused to be removed, got %v", remove)
+	}
+}
+
+func TestNetworkCommands(t *testing.T) {
+	calls := fakeDockerAPI(t, false)
+	create := &createNetworksCommand{Names: []string{"dogo_web"}}
+	create.Execute()
+	remove := &removeNetworksCommand{Names: []string{"dogo_old"}}
+	remove.Execute()
+
+	if create.AnyError() || remove.AnyError() {
+		t.Errorf("expected no errors, got %v %v", create.LogArray, remove.LogArray)
+	}
+	requests, _ := calls()
+	for _, expected := range []string{"POST /networks/create", "DELETE /networks/dogo_old"} {
+		if !strings.Contains(strings.Join(requests, "|"), expected) {
+			t.Errorf("expected %v, got %v", expected, requests)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// imagesDir is where images are saved locally with 'docker save' before being sent to servers
+const imagesDir = ".dogocache/images"
+
+// imageFilesPattern matches the image archives sent to servers (see remoteImagePath)
+const imageFilesPattern = "/tmp/dogo-image-*.tar"
+
+// containerdImageStore returns if docker stores images with containerd (the default on
+// new installs), given the driver status of 'docker info'. 'docker load' doesn't skip the
+// layers it has then: it needs all of them in the archive.
+func containerdImageStore(driverStatus [][2]string) bool {
+	for _, status := range driverStatus {
+		if status[0] == "driver-type" && status[1] == "io.containerd.snapshotter.v1" {
+			return true
+		}
+	}
+	return false
+}
+
+// chainIDs returns the chain id of each layer of an image, given the diff ids of
+// its layers (bottom first). A layer is only the same as a layer on another
+// machine if the layers below it are the same too, so this is how 'docker load'
+// finds the layers it already has.
+func chainIDs(diffIDs []string) []string {
+	chains := make([]string, len(diffIDs))
+	for i, diffID := range diffIDs {
+		if i == 0 {
+			chains[i] = diffID
+			continue
+		}
+		sum := sha256.Sum256([]byte(chains[i-1] + " " + diffID))
+		chains[i] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return chains
+}
+
+// missingLayers returns how many of the layers of the image the server doesn't have
+func missingLayers(diffIDs []string, remoteLayers map[string]bool) int {
+	missing := 0
+	for _, chain := range chainIDs(diffIDs) {
+		if !remoteLayers[chain] {
+			missing++
+		}
+	}
+	return missing
+}
+
+// readArchiveManifest reads manifest.json from an image archive made by 'docker save',
+// and the symlinks in it (name => target)
+func readArchiveManifest(archive string) ([]map[string]interface{}, map[string]string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var manifest []map[string]interface{}
+	links := make(map[string]string)
+	r := tar.NewReader(f)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case header.Name == "manifest.json":
+			if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+				return nil, nil, fmt.Errorf("Could not read manifest.json of %v: %v", archive, err)
+			}
+		case header.Typeflag == tar.TypeSymlink:
+			links[header.Name] = path.Join(path.Dir(header.Name), header.Linkname)
+		}
+	}
+	if len(manifest) != 1 {
+		return nil, nil, fmt.Errorf("Expected a single image in %v, found %v", archive, len(manifest))
+	}
+	return manifest, links, nil
+}
+
+// deltaArchive writes the image archive made by 'docker save' to w, without the layers
+// the server already has. 'docker load' only reads the layers it doesn't have, so the
+// result loads like the full archive. The image is tagged with tags when it's loaded.
+func deltaArchive(archive string, diffIDs []string, remoteLayers map[string]bool, tags []string, w io.Writer) error {
+	manifest, links, err := readArchiveManifest(archive)
+	if err != nil {
+		return err
+	}
+	layers := make([]string, 0)
+	if arr, ok := manifest[0]["Layers"].([]interface{}); ok {
+		for _, l := range arr {
+			if s, ok := l.(string); ok {
+				layers = append(layers, s)
+			}
+		}
+	}
+	if len(layers) != len(diffIDs) {
+		return fmt.Errorf("The image in %v has %v layers, expected %v", archive, len(layers), len(diffIDs))
+	}
+
+	// the layer files of the missing layers (and the files their symlinks point to) are needed
+	needed := make(map[string]bool)
+	for i, chain := range chainIDs(diffIDs) {
+		if !remoteLayers[chain] {
+			for name := layers[i]; name != "" && !needed[name]; name = links[name] {
+				needed[name] = true
+			}
+		}
+	}
+	skip := make(map[string]bool)
+	for _, name := range layers {
+		if !needed[name] {
+			skip[name] = true
+		}
+	}
+
+	manifest[0]["RepoTags"] = tags
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := tar.NewReader(f)
+	tw := tar.NewWriter(w)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if skip[header.Name] {
+			continue
+		}
+		if header.Name == "manifest.json" {
+			header.Size = int64(len(manifestBytes))
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := tw.Write(manifestBytes); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// saveImage saves the image to imagesDir with 'docker save', and returns the path of
+// the archive. Images are only saved once, since their id is the hash of their content.
+func saveImage(imageID string) (string, error) {
+	archive := filepath.Join(imagesDir, strings.TrimPrefix(imageID, "sha256:")+".tar")
+	if _, err := os.Stat(archive); err == nil {
+		return archive, nil
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	content, err := client.ImageSave(context.Background(), []string{imageID})
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	if err := os.MkdirAll(imagesDir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.Create(archive + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archive + ".tmp")
+		return "", err
+	}
+	return archive, os.Rename(archive+".tmp", archive)
+}
+
+type savedImage struct {
+	once    sync.Once
+	archive string
+	err     error
+}
+
+var saveLock = sync.Mutex{}
+var saveMap = make(map[string]*savedImage)
+
+// remoteImagePath returns a new path on the server to send an image archive to
+func remoteImagePath(imageID string) string {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	id := strings.TrimPrefix(imageID, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return strings.Replace(imageFilesPattern, "*", id+"-"+hex.EncodeToString(random), 1)
+}
+
+// sendImageCommand sends the layers of an image the server doesn't have over the
+// ssh connection, to RemotePath. It's loaded on the server by a containerCommand.
+type sendImageCommand struct {
+	commandtree.Command
+	connection   schema.ServerConnection
+	imageID      string
+	tag          string
+	diffIDs      []string
+	remoteLayers map[string]bool
+	RemotePath   string
+}
+
+func (c *sendImageCommand) Describe() []string {
+	return []string{fmt.Sprintf("send %v (%v of %v layers, the server has the rest) to %v", c.tag, missingLayers(c.diffIDs, c.remoteLayers), len(c.diffIDs), c.RemotePath)}
+}
+
+func (c *sendImageCommand) Execute() {
+	// In inprocess cache to ensure that if deploying to
+	// 20 servers, the image is only saved once.
+	saveLock.Lock()
+	saved, found := saveMap[c.imageID]
+	if !found {
+		saved = &savedImage{}
+		saveMap[c.imageID] = saved
+	}
+	saveLock.Unlock()
+
+	saved.once.Do(func() {
+		c.Logf("docker save %v (%v)", c.tag, c.imageID)
+		saved.archive, saved.err = saveImage(c.imageID)
+	})
+	if saved.err != nil {
+		c.Errf("Could not save image %v: %v", c.tag, saved.err)
+		return
+	}
+
+	// the size of the archive is sent first, so it's built twice
+	counter := &countingWriter{}
+	if err := deltaArchive(saved.archive, c.diffIDs, c.remoteLayers, []string{c.tag}, counter); err != nil {
+		c.Errf("Could not read image archive %v: %v", saved.archive, err)
+		return
+	}
+
+	c.Logf("Sending %v of %v layers of %v (%.1f MB)", missingLayers(c.diffIDs, c.remoteLayers), len(c.diffIDs), c.tag, float64(counter.n)/1024/1024)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(deltaArchive(saved.archive, c.diffIDs, c.remoteLayers, []string{c.tag}, writer))
+	}()
+	err := c.connection.WriteFile(c.RemotePath, 0600, counter.n, reader, false, c.SetProgress)
+	reader.Close()
+	if err != nil {
+		c.Errf("Could not send image %v: %v", c.tag, err)
+	}
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
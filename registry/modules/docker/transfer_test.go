@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestChainIDs(t *testing.T) {
+	sum := sha256.Sum256([]byte("sha256:a sha256:b"))
+	chains := chainIDs([]string{"sha256:a", "sha256:b"})
+	if len(chains) != 2 || chains[0] != "sha256:a" || chains[1] != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected chain ids: %v", chains)
+	}
+	if other := chainIDs([]string{"sha256:c", "sha256:b"}); other[1] == chains[1] {
+		t.Errorf("expected the chain id to depend on the layers below")
+	}
+}
+
+// writeArchive writes an image archive like 'docker save' does, with a layer
+// that's a symlink to another layer (the same layer used twice)
+func writeArchive(t *testing.T) string {
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := tar.NewWriter(f)
+	add := func(name string, content string) {
+		w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		w.Write([]byte(content))
+	}
+	add("l1/layer.tar", "layer 1")
+	add("l2/layer.tar", "layer 2")
+	w.WriteHeader(&tar.Header{Name: "l3/layer.tar", Linkname: "../l1/layer.tar", Typeflag: tar.TypeSymlink})
+	add("config.json", "{}")
+	add("manifest.json", `[{"Config":"config.json","RepoTags":null,"Layers":["l1/layer.tar","l2/layer.tar","l3/layer.tar"]}]`)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func readArchive(t *testing.T, b []byte) (names []string, manifest []map[string]interface{}) {
+	r := tar.NewReader(bytes.NewReader(b))
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		if header.Name == "manifest.json" {
+			json.NewDecoder(r).Decode(&manifest)
+		}
+	}
+	sort.Strings(names)
+	return names, manifest
+}
+
+func TestContainerdImageStore(t *testing.T) {
+	if containerdImageStore([][2]string{{"Backing Filesystem", "extfs"}, {"Supports d_type", "true"}}) {
+		t.Errorf("expected overlay2 to not be the containerd image store")
+	}
+	if !containerdImageStore([][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}) {
+		t.Errorf("expected the containerd image store")
+	}
+}
+
+func TestDeltaArchive(t *testing.T) {
+	archive := writeArchive(t)
+	diffIDs := []string{"sha256:1", "sha256:2", "sha256:1"}
+	chains := chainIDs(diffIDs)
+
+	// the server has the first two layers, so only the last is sent (and the file its symlink points to)
+	buf := bytes.NewBuffer(nil)
+	if err := deltaArchive(archive, diffIDs, map[string]bool{chains[0]: true, chains[1]: true}, []string{"web:latest"}, buf); err != nil {
+		t.Fatal(err)
+	}
+	names, manifest := readArchive(t, buf.Bytes())
+	if strings.Join(names, ",") != "config.json,l1/layer.tar,l3/layer.tar,manifest.json" {
+		t.Errorf("unexpected files: %v", names)
+	}
+	if tags, ok := manifest[0]["RepoTags"].([]interface{}); !ok || len(tags) != 1 || tags[0] != "web:latest" {
+		t.Errorf("expected the image to be tagged, got %v", manifest)
+	}
+
+	// the server has all of them
+	buf.Reset()
+	if err := deltaArchive(archive, diffIDs, map[string]bool{chains[0]: true, chains[1]: true, chains[2]: true}, nil, buf); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := readArchive(t, buf.Bytes()); strings.Join(names, ",") != "config.json,manifest.json" {
+		t.Errorf("expected no layers, got %v", names)
+	}
+
+	if err := deltaArchive(archive, diffIDs[:2], nil, nil, io.Discard); err == nil {
+		t.Errorf("expected an error when the layers don't match the archive")
+	}
+}
@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/oliverkofoed/dogo/commandtree"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+// undeclaredContainers finds the containers started by dogo (they have the 'dogo' label)
+// which aren't declared anymore. The previous container of a declared container is
+// left alone, so it can still be restored by hand after a failed deploy.
+func undeclaredContainers(remoteState *state, records []*schema.ContainerRecord) []types.Container {
+	declared := make(map[string]bool)
+	for _, record := range records {
+		if record.Name != "" {
+			declared["/"+record.Name] = true
+			declared["/"+record.Name+previousContainerSuffix] = true
+		}
+	}
+
+	result := make([]types.Container, 0)
+	for _, container := range remoteState.Containers {
+		if _, byDogo := container.Labels["dogo"]; !byDogo {
+			continue
+		}
+		found := false
+		for _, name := range container.Names {
+			found = found || declared[name]
+		}
+		if !found {
+			result = append(result, container)
+		}
+	}
+	return result
+}
+
+type removeContainersCommand struct {
+	commandtree.Command
+	IDs    []string
+	Names  []string
+	Reason string // why they're removed, e.g. "no longer declared"
+}
+
+func (c *removeContainersCommand) Describe() []string {
+	return []string{"stop and remove containers that are " + c.Reason + ": " + strings.Join(c.Names, ", ")}
+}
+
+func (c *removeContainersCommand) Execute() {
+	client, err := getClient()
+	if err != nil {
+		c.Errf("Could not get docker client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	for i, id := range c.IDs {
+		c.Logf("Stopping and removing %v, it's %v", c.Names[i], c.Reason)
+		if err := client.ContainerStop(context.Background(), id, nil); err != nil {
+			c.Errf("Could not stop container %v: %v", c.Names[i], err)
+			continue
+		}
+		if err := client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{}); err != nil {
+			c.Errf("Could not remove container %v: %v", c.Names[i], err)
+		}
+	}
+}
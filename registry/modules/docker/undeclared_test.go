@@ -0,0 +1,35 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/oliverkofoed/dogo/schema"
+)
+
+func TestUndeclaredContainers(t *testing.T) {
+	dogo := map[string]string{"dogo": "version"}
+	remoteState := &state{
+		Containers: []types.Container{
+			{ID: "1", Names: []string{"/web"}, State: "running", Labels: dogo},
+			{ID: "2", Names: []string{"/web_dogo_previous"}, State: "exited", Labels: dogo}, // kept for restoring web
+			{ID: "3", Names: []string{"/api"}, State: "running", Labels: dogo},
+			{ID: "4", Names: []string{"/api_dogo_previous"}, State: "exited", Labels: dogo},
+			{ID: "5", Names: []string{"/old"}, State: "exited", Labels: dogo},
+			{ID: "6", Names: []string{"/mine"}, State: "running"}, // not started by dogo
+		},
+	}
+
+	undeclared := undeclaredContainers(remoteState, []*schema.ContainerRecord{{Name: "web"}, {Cron: "* * * * *"}})
+	ids := ""
+	for _, container := range undeclared {
+		ids += container.ID
+	}
+	if ids != "345" {
+		t.Errorf("expected api, its previous container and old to be undeclared, got %v", ids)
+	}
+
+	if undeclared := undeclaredContainers(remoteState, nil); len(undeclared) != 5 {
+		t.Errorf("expected all dogo containers to be undeclared without records, got %v", len(undeclared))
+	}
+}
@@ -3,6 +3,7 @@ package file
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/hex"
 	"io"
 	"os"
 	"strconv"
@@ -93,13 +94,36 @@ var Manager = schema.ModuleManager{
 		remoteState := c.State.(*state)
 		modules := c.Modules.([]*File)
 
+		// files aren't part of the deployment record (only their checksums), so
+		// rolling back can only verify that they're still the same.
+		if c.Rollback != nil {
+			for _, record := range c.Rollback.Files {
+				remote, found := remoteState.Files[record.Path]
+				if !found {
+					c.Logf("warning: %v is missing (or no longer part of the configuration), it can't be restored by a rollback", record.Path)
+					continue
+				}
+				c.Record.Files = append(c.Record.Files, &schema.FileRecord{
+					Path:     record.Path,
+					Size:     remote.Size,
+					Mode:     remote.Mode,
+					Checksum: hex.EncodeToString(remote.Checksum),
+				})
+				if remote.Size != record.Size || remote.Mode != record.Mode || (remote.Checksum != nil && record.Checksum != "" && hex.EncodeToString(remote.Checksum) != record.Checksum) {
+					c.Logf("warning: %v has changed since that deployment, it can't be restored by a rollback", record.Path)
+				}
+			}
+			return nil
+		}
+
 		for _, f := range modules {
 			path, err := f.RemotePath.Render(nil)
 			if err != nil {
 				return err
 			}
 
-			// compare with remote (if we have it)
+			// compare with remote (if we have it). The content is only read if the
+			// checksum is used, or the file must be uploaded.
 			if remote, found := remoteState.Files[path]; found {
 				localFile, localSize, localMode, err := getFile(c, f)
 				if err != nil {
@@ -107,24 +131,28 @@ var Manager = schema.ModuleManager{
 				}
 
 				if localSize == remote.Size && uint32(localMode) == remote.Mode {
-					if f.Checksum {
-						localChecksum, err := calcChecksum(localFile)
-						if err != nil {
-							localFile.Close()
-							return err
-						}
-						if bytes.Equal(localChecksum, remote.Checksum) {
-							// yay, they're equal, nothing to do!
-							localFile.Close()
-							continue
-						}
-					} else {
-						// yay, file is close enough!
+					record := &schema.FileRecord{Path: path, Size: localSize, Mode: uint32(localMode)}
+					if !f.Checksum {
+						// yay, file is close enough! (it's recorded without a checksum)
 						localFile.Close()
+						c.Record.Files = append(c.Record.Files, record)
+						continue
+					}
+
+					localChecksum, err := calcChecksum(localFile)
+					localFile.Close()
+					if err != nil {
+						return err
+					}
+					if bytes.Equal(localChecksum, remote.Checksum) {
+						// yay, they're equal, nothing to do!
+						record.Checksum = hex.EncodeToString(localChecksum)
+						c.Record.Files = append(c.Record.Files, record)
 						continue
 					}
+				} else {
+					localFile.Close()
 				}
-				localFile.Close()
 			}
 
 			// read file content
@@ -132,13 +160,22 @@ var Manager = schema.ModuleManager{
 			if err != nil {
 				return err
 			}
-
 			content, err := ioutil.ReadAll(localFile)
 			if err != nil {
 				localFile.Close()
 				return err
 			}
 			localFile.Close()
+			localChecksum, err := calcChecksum(bytes.NewReader(content))
+			if err != nil {
+				return err
+			}
+			c.Record.Files = append(c.Record.Files, &schema.FileRecord{
+				Path:     path,
+				Size:     int64(len(content)),
+				Mode:     uint32(localMode),
+				Checksum: hex.EncodeToString(localChecksum),
+			})
 
 			// add upload command
 			c.RemoteCommands.Add("Save "+path, &writeFileCommand{
@@ -193,6 +230,10 @@ type writeFileCommand struct {
 	FileMode uint32
 }
 
+func (c *writeFileCommand) Describe() []string {
+	return []string{fmt.Sprintf("write %v bytes to %v (mode %v)", len(c.Content), c.Path, os.FileMode(c.FileMode))}
+}
+
 func (c *writeFileCommand) Execute() {
 	// remove it first in case it exists, to ensure perm gets set correctly.
 	os.Remove(c.Path)
@@ -0,0 +1,20 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDescribeChangesOrder(t *testing.T) {
+	targetJumps := map[string][]rule{
+		"OUTPUT":  {{"-j", "dogo_output"}},
+		"INPUT":   {{"-j", "dogo_input"}},
+		"FORWARD": {{"-j", "dogo_forward"}},
+	}
+	expected := "ipv4: FORWARD + -j dogo_forward|ipv4: INPUT + -j dogo_input|ipv4: OUTPUT + -j dogo_output"
+	for i := 0; i < 10; i++ {
+		if lines := describeChanges("ipv4", map[string]*chain{}, map[string]*chain{}, targetJumps); strings.Join(lines, "|") != expected {
+			t.Fatalf("unexpected changes: %v", lines)
+		}
+	}
+}
@@ -74,34 +74,50 @@ var Manager = schema.ModuleManager{
 		modules := c.Modules.([]*Firewall)
 		cmd := &syncFirewallCommand{}
 
-		if !remoteState.Supported {
+		// render the rules (or use the ones from the deployment we're rolling back to)
+		skipAll := len(modules) > 0
+		records := make([]*schema.FirewallRecord, 0, len(modules))
+		if c.Rollback != nil {
+			skipAll = len(c.Rollback.Firewall) == 0 // the firewall wasn't managed by that deployment
+			records = c.Rollback.Firewall
+		} else {
 			for _, module := range modules {
-				if !module.Skip {
-					return fmt.Errorf("Firewall not supported on the system.")
+				skipAll = module.Skip && skipAll
+				if module.Skip {
+					continue
 				}
+				record, err := renderRule(module)
+				if err != nil {
+					return err
+				}
+				records = append(records, record)
+			}
+		}
+
+		if !remoteState.Supported {
+			if len(records) > 0 {
+				return fmt.Errorf("Firewall not supported on the system.")
 			}
 			return nil
 		}
 
 		// check if skip all
-		if len(modules) > 0 {
-			skipAll := true
-			for _, module := range modules {
-				skipAll = module.Skip && skipAll
-			}
-			if skipAll {
-				return nil
-			}
+		if skipAll {
+			return nil
 		}
+		c.Record.Firewall = records
+
+		cmd.ipv4RemoteChains = remoteState.ChainsIPV4
+		cmd.ipv6RemoteChains = remoteState.ChainsIPV6
 
 		// calculate ipv4 rules
-		cmd.IPV4TargetChains, cmd.IPV4TargetJumps, cmd.IPV4DefaultPolicy, cmd.IPV4Sync, err = buildCommand(c, false, modules, remoteState.ChainsIPV4)
+		cmd.IPV4TargetChains, cmd.IPV4TargetJumps, cmd.IPV4DefaultPolicy, cmd.IPV4Sync, err = buildCommand(c, false, records, remoteState.ChainsIPV4)
 		if err != nil {
 			return err
 		}
 
 		// calculate ipv6 rules
-		cmd.IPV6TargetChains, cmd.IPV6TargetJumps, cmd.IPV6DefaultPolicy, cmd.IPV6Sync, err = buildCommand(c, true, modules, remoteState.ChainsIPV6)
+		cmd.IPV6TargetChains, cmd.IPV6TargetJumps, cmd.IPV6DefaultPolicy, cmd.IPV6Sync, err = buildCommand(c, true, records, remoteState.ChainsIPV6)
 		if err != nil {
 			return err
 		}
@@ -136,32 +152,40 @@ var Manager = schema.ModuleManager{
 	},
 }
 
-func buildCommand(c *schema.CalculateCommandsArgs, isIPV6 bool, modules []*Firewall, remoteChains map[string]*chain) (targetChains map[string]*chain, targetJumps map[string][]rule, defaultPolicy map[string]string, doSync bool, bad error) {
+func renderRule(module *Firewall) (*schema.FirewallRecord, error) {
+	fromString, err := module.From.Render(nil)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := module.Interface.Render(nil)
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := module.Protocol.Render(nil)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == "" || !(protocol == "udp" || protocol == "tcp") {
+		protocol = "tcp"
+	}
+	return &schema.FirewallRecord{
+		Protocol:  protocol,
+		Port:      module.Port,
+		From:      fromString,
+		Interface: iface,
+	}, nil
+}
+
+func buildCommand(c *schema.CalculateCommandsArgs, isIPV6 bool, records []*schema.FirewallRecord, remoteChains map[string]*chain) (targetChains map[string]*chain, targetJumps map[string][]rule, defaultPolicy map[string]string, doSync bool, bad error) {
 	targetChains = make(map[string]*chain)
 	targetJumps = make(map[string][]rule)
 	defaultPolicy = make(map[string]string)
 	used := make(map[string]bool)
 
-	for _, module := range modules {
-		if module.Skip {
-			continue
-		}
-
-		fromString, err := module.From.Render(nil)
-		if err != nil {
-			return nil, nil, nil, false, err
-		}
-		iface, err := module.Interface.Render(nil)
-		if err != nil {
-			return nil, nil, nil, false, err
-		}
-		protocol, err := module.Protocol.Render(nil)
-		if err != nil {
-			return nil, nil, nil, false, err
-		}
-		if protocol == "" || !(protocol == "udp" || protocol == "tcp") {
-			protocol = "tcp"
-		}
+	for _, record := range records {
+		fromString := record.From
+		iface := record.Interface
+		protocol := record.Protocol
 
 		addresses := strings.Split(fromString, ",")
 		for _, address := range addresses {
@@ -217,7 +241,7 @@ func buildCommand(c *schema.CalculateCommandsArgs, isIPV6 bool, modules []*Firew
 				r = append(r, "-j", "ACCEPT")
 
 				// add rule to proper chain
-				chainName := fmt.Sprintf("%v%v_%v", prefix, protocol, module.Port)
+				chainName := fmt.Sprintf("%v%v_%v", prefix, protocol, record.Port)
 				key := chainName + ":" + r.String()
 				if _, found := used[key]; !found {
 					c, found := targetChains[chainName]
@@ -324,6 +348,87 @@ type syncFirewallCommand struct {
 	IPV6TargetChains  map[string]*chain
 	IPV6TargetJumps   map[string][]rule
 	IPV6DefaultPolicy map[string]string
+	ipv4RemoteChains  map[string]*chain
+	ipv6RemoteChains  map[string]*chain
+}
+
+func (c *syncFirewallCommand) Describe() []string {
+	lines := make([]string, 0)
+	if c.IPV4Sync {
+		lines = append(lines, describeChanges("iptables", c.ipv4RemoteChains, c.IPV4TargetChains, c.IPV4TargetJumps)...)
+	}
+	if c.IPV6Sync {
+		lines = append(lines, describeChanges("ip6tables", c.ipv6RemoteChains, c.IPV6TargetChains, c.IPV6TargetJumps)...)
+	}
+	return lines
+}
+
+// describeChanges lists the chains and rules that differ between the remote
+// chains and the target chains, prefixed with '+' (added) or '-' (removed).
+func describeChanges(name string, remoteChains map[string]*chain, targetChains map[string]*chain, targetJumps map[string][]rule) []string {
+	lines := make([]string, 0)
+
+	chainNames := make(map[string]*chain)
+	for chainName, ch := range targetChains {
+		chainNames[chainName] = ch
+	}
+	for chainName, ch := range remoteChains {
+		if _, found := chainNames[chainName]; !found && strings.HasPrefix(chainName, prefix) {
+			chainNames[chainName] = ch
+		}
+	}
+
+	for _, chainName := range sortKeys(chainNames) {
+		target, inTarget := targetChains[chainName]
+		remote, inRemote := remoteChains[chainName]
+		if !inRemote {
+			lines = append(lines, fmt.Sprintf("%v: + chain %v", name, chainName))
+			remote = &chain{}
+		}
+		if !inTarget {
+			lines = append(lines, fmt.Sprintf("%v: - chain %v", name, chainName))
+			target = &chain{}
+		}
+		for _, r := range remote.Rules {
+			if !containsRule(target.Rules, r) {
+				lines = append(lines, fmt.Sprintf("%v: %v - %v", name, chainName, r.String()))
+			}
+		}
+		for _, r := range target.Rules {
+			if !containsRule(remote.Rules, r) {
+				lines = append(lines, fmt.Sprintf("%v: %v + %v", name, chainName, r.String()))
+			}
+		}
+	}
+
+	jumpChains := make([]string, 0, len(targetJumps))
+	for chainName := range targetJumps {
+		jumpChains = append(jumpChains, chainName)
+	}
+	sort.Strings(jumpChains)
+	for _, chainName := range jumpChains {
+		jumps := targetJumps[chainName]
+		var existing []rule
+		if remote, found := remoteChains[chainName]; found {
+			existing = remote.Rules
+		}
+		for _, r := range jumps {
+			if !containsRule(existing, r) {
+				lines = append(lines, fmt.Sprintf("%v: %v + %v", name, chainName, r.String()))
+			}
+		}
+	}
+
+	return lines
+}
+
+func containsRule(rules []rule, r rule) bool {
+	for _, x := range rules {
+		if x.equal(r) {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *syncFirewallCommand) Execute() {
@@ -3,6 +3,11 @@ package registry
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"os"
@@ -55,6 +60,7 @@ func GobRegister() {
 	snobgob.Register(commandtree.NewExecCommand("", "", "", "", ""))
 	snobgob.Register(commandtree.NewBashCommands("", "", "", "", ""))
 	snobgob.Register(DefaultStateQuery{})
+	snobgob.Register(SaveDeploymentCommand{})
 
 	for _, m := range ModuleManagers {
 		if m.ModulePrototype != nil {
@@ -120,3 +126,62 @@ func (c *GetStateCommand) Execute() {
 
 	c.SetResult(state)
 }
+
+// SaveDeploymentCommand stores a deployment record (json) on the server, so it's
+// available for rollbacks from other machines. Only the newest records are kept.
+type SaveDeploymentCommand struct {
+	commandtree.Command
+	Environment string
+	Number      int
+	Content     []byte
+	Keep        int
+}
+
+func (c *SaveDeploymentCommand) Execute() {
+	dir := filepath.Join(schema.DeploymentsPath, c.Environment)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		c.Errf("Could not create %v: %v", dir, err)
+		return
+	}
+
+	// the number is taken, if another deploy (from somewhere else) recorded it first
+	path := filepath.Join(dir, fmt.Sprintf("%v.json", c.Number))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			c.Errf("Deployment record #%v of %v already exists on the server. Was it deployed from somewhere else at the same time?", c.Number, c.Environment)
+		} else {
+			c.Errf("Could not write deployment record to %v: %v", path, err)
+		}
+		return
+	}
+	_, err = f.Write(c.Content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.Errf("Could not write deployment record to %v: %v", path, err)
+		return
+	}
+
+	// remove old records
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		c.Errf("Could not list %v: %v", dir, err)
+		return
+	}
+	numbers := make([]int, 0, len(files))
+	for _, f := range files {
+		if n, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".json")); err == nil && strings.HasSuffix(f.Name(), ".json") {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	for len(numbers) > c.Keep && c.Keep > 0 {
+		if err := os.Remove(filepath.Join(dir, fmt.Sprintf("%v.json", numbers[0]))); err != nil {
+			c.Errf("Could not remove old deployment record: %v", err)
+			return
+		}
+		numbers = numbers[1:]
+	}
+}
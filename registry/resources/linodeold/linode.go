@@ -68,6 +68,96 @@ func (g *LinodeGroup) Validate() error {
 	return nil
 }
 
+// serverLabel is the label of the server in linode
+func serverLabel(g *LinodeGroup, s *Linode) string {
+	label := g.DecommissionTag
+	if len(label) > 0 {
+		label += "_"
+	}
+	return label + s.Name
+}
+
+// lookupCached finds a server that was already provisioned in .dogocache/linode/,
+// if it can be reached with ssh.
+func lookupCached(g *LinodeGroup, s *Linode) (bool, error) {
+	privateKey, err := s.SSHPrivateKey.RenderFileBytes(nil)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir, serverLabel(g, s)))
+	if err != nil {
+		return false, nil
+	}
+	info := &machineInfo{}
+	if err := snobgob.NewDecoder(bytes.NewReader(b)).Decode(info); err != nil || len(info.PublicIPs) == 0 {
+		return false, nil
+	}
+	if err := ssh.WaitForSSH(info.PublicIPs[0], 22, "root", "", privateKey, time.Millisecond*200); err != nil {
+		return false, nil
+	}
+	s.info = info
+	return true, nil
+}
+
+// lookup finds a server that was already provisioned, without creating it. Servers
+// that aren't in .dogocache/linode/ (e.g. on a fresh checkout) are looked up with
+// the linode api, and added to the cache.
+func lookup(g *LinodeGroup, s *Linode, l schema.Logger) (bool, error) {
+	if found, err := lookupCached(g, s); err != nil || found {
+		return found, err
+	}
+
+	account, err := getAccount(g)
+	if err != nil {
+		return false, err
+	}
+	servers, err := account.listServers(l)
+	if err != nil {
+		return false, err
+	}
+	server, found := servers[serverLabel(g, s)]
+	if !found {
+		return false, nil
+	}
+	info, err := account.machineInfo(server)
+	if err != nil {
+		return false, fmt.Errorf("Could not get ip for server %v: %v", s.Name, err)
+	}
+	saveMachineInfo(serverLabel(g, s), info)
+	s.info = info
+	return true, nil
+}
+
+// getAccount returns the account of the api key of the group
+func getAccount(g *LinodeGroup) (*linodeAccount, error) {
+	apikey, err := g.APIKey.Render(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	accountsLock.Lock()
+	defer accountsLock.Unlock()
+	account, found := accounts[apikey]
+	if !found {
+		account = &linodeAccount{client: &client{apikey: apikey}}
+		accounts[apikey] = account
+	}
+	return account, nil
+}
+
+// saveMachineInfo writes the info of the server to .dogocache/linode/
+func saveMachineInfo(label string, info *machineInfo) {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return
+	}
+	b := bytes.NewBuffer(nil)
+	encoder := snobgob.NewEncoder(b)
+	if err := encoder.Encode(info); err == nil {
+		ioutil.WriteFile(filepath.Join(cacheDir, label), b.Bytes(), 0700)
+	}
+}
+
 var accountsLock sync.RWMutex
 var accounts = make(map[string]*linodeAccount)
 var oneList sync.Once
@@ -78,27 +168,15 @@ var Manager = schema.ResourceManager{
 	Name:              "linodeold",
 	ResourcePrototype: &Linode{},
 	GroupPrototype:    &LinodeGroup{},
+	Lookup: func(group interface{}, resource interface{}, l schema.Logger) (bool, error) {
+		return lookup(group.(*LinodeGroup), resource.(*Linode), l)
+	},
 	Provision: func(group interface{}, resource interface{}, l schema.Logger) error {
 		g := group.(*LinodeGroup)
 		s := resource.(*Linode)
 
-		// get the api key.
-		apikey, err := g.APIKey.Render(nil)
-		if err != nil {
-			return err
-		}
-
 		// fin the server label
-		label := g.DecommissionTag
-		if len(label) > 0 {
-			label += "_"
-		}
-		label += s.Name
-
-		// ensure folder exists
-		if err := os.MkdirAll(cacheDir, 0700); err != nil {
-			return err
-		}
+		label := serverLabel(g, s)
 
 		// get private key and root password
 		privateKey, err := s.SSHPrivateKey.RenderFileBytes(nil)
@@ -107,26 +185,17 @@ var Manager = schema.ResourceManager{
 		}
 
 		// load .dogocache/linode/(label.name)
-		info := &machineInfo{}
-		machineInfoFilePath := filepath.Join(cacheDir, label)
-		if b, err := ioutil.ReadFile(machineInfoFilePath); err == nil {
-			decoder := snobgob.NewDecoder(bytes.NewReader(b))
-			if err := decoder.Decode(info); err == nil {
-				if err := ssh.WaitForSSH(info.PublicIPs[0], 22, "root", "", privateKey, time.Millisecond*200); err == nil {
-					s.info = info
-					return nil
-				}
-			}
+		if found, err := lookupCached(g, s); err != nil {
+			return err
+		} else if found {
+			return nil
 		}
 
 		// get the manager for that account
-		accountsLock.Lock()
-		account, found := accounts[apikey]
-		if !found {
-			account = &linodeAccount{client: &client{apikey: apikey}}
-			accounts[apikey] = account
+		account, err := getAccount(g)
+		if err != nil {
+			return err
 		}
-		accountsLock.Unlock()
 
 		// list servers.
 		servers, err := account.listServers(l)
@@ -184,31 +253,12 @@ var Manager = schema.ResourceManager{
 			}
 		}
 
-		// create stored info
-		info = &machineInfo{
-			PublicIPs:  make([]string, 0),
-			PrivateIPs: make([]string, 0),
-		}
-
-		// get the ip
-		ips, err := account.client.lindeIPList(server.LINODEID)
+		// get the ip, and write it to disk
+		info, err := account.machineInfo(server)
 		if err != nil {
 			return fmt.Errorf("Could not get ip for server %v: %v", s.Name, err)
 		}
-		for _, ip := range ips {
-			if ip.ISPUBLIC == 1 {
-				info.PublicIPs = append(info.PublicIPs, ip.IPADDRESS)
-			} else {
-				info.PrivateIPs = append(info.PrivateIPs, ip.IPADDRESS)
-			}
-		}
-
-		// write info to disk
-		b := bytes.NewBuffer(nil)
-		encoder := snobgob.NewEncoder(b)
-		if err := encoder.Encode(info); err == nil {
-			ioutil.WriteFile(machineInfoFilePath, b.Bytes(), 0700)
-		}
+		saveMachineInfo(label, info)
 		s.info = info
 
 		// wait for machine to be ssh accessible.
@@ -284,6 +334,26 @@ type linodeAccount struct {
 	listServerCache map[string]linode
 }
 
+// machineInfo gets the ips of the server
+func (a *linodeAccount) machineInfo(server linode) (*machineInfo, error) {
+	info := &machineInfo{
+		PublicIPs:  make([]string, 0),
+		PrivateIPs: make([]string, 0),
+	}
+	ips, err := a.client.lindeIPList(server.LINODEID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.ISPUBLIC == 1 {
+			info.PublicIPs = append(info.PublicIPs, ip.IPADDRESS)
+		} else {
+			info.PrivateIPs = append(info.PrivateIPs, ip.IPADDRESS)
+		}
+	}
+	return info, nil
+}
+
 func (a *linodeAccount) decommissionServer(node linode, l schema.Logger) error {
 	// shutdown
 	l.Logf("shutting down")
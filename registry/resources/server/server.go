@@ -50,6 +50,9 @@ var Manager = schema.ResourceManager{
 	Provision: func(group interface{}, resource interface{}, l schema.Logger) error {
 		return nil
 	},
+	Lookup: func(group interface{}, resource interface{}, l schema.Logger) (bool, error) {
+		return true, nil
+	},
 	FindUnused: func(shouldExist map[interface{}][]string, decommisionRoot *commandtree.Command, l schema.Logger) ([]string, error) {
 		return []string{}, nil
 	},
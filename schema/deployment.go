@@ -0,0 +1,89 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeploymentRecord is what a deploy put on a single server. Modules fill it in
+// while calculating commands, and when rolling back they're given a previous
+// record (CalculateCommandsArgs.Rollback) to calculate commands from instead
+// of the current configuration.
+type DeploymentRecord struct {
+	Containers []*ContainerRecord `json:"containers,omitempty"`
+	Files      []*FileRecord      `json:"files,omitempty"`
+	Firewall   []*FirewallRecord  `json:"firewall,omitempty"`
+}
+
+// ContainerRecord is a docker container (or cron job) with its options rendered.
+type ContainerRecord struct {
+	Name        string            `json:"name,omitempty"`
+	Tag         string            `json:"tag"`
+	ImageID     string            `json:"image_id"`
+	Folder      string            `json:"folder,omitempty"`
+	Command     string            `json:"command,omitempty"`
+	Options     []string          `json:"options,omitempty"`
+	Entrypoint  string            `json:"entrypoint,omitempty"`
+	Ports       []string          `json:"ports,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Volumes     []string          `json:"volumes,omitempty"`
+	Restart     string            `json:"restart,omitempty"`
+	Network     string            `json:"network,omitempty"`
+	Aliases     []string          `json:"aliases,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Memory      string            `json:"memory,omitempty"`
+	CPUs        string            `json:"cpus,omitempty"`
+	User        string            `json:"user,omitempty"`
+	Cron        string            `json:"cron,omitempty"`
+	CronUser    string            `json:"cron_user,omitempty"`
+	HealthCheck *HealthCheck      `json:"healthcheck,omitempty"`
+}
+
+// HealthCheck is a container health check with its templates rendered. Exactly
+// one of URL, Port and Command is set.
+type HealthCheck struct {
+	URL      string `json:"url,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Timeout  string `json:"timeout"`
+	Interval string `json:"interval"`
+	Retries  int    `json:"retries"`
+}
+
+// FileRecord is a file written to the server. Only the checksum of the content is kept.
+type FileRecord struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Mode     uint32 `json:"mode"`
+	Checksum string `json:"checksum"` // "" for unchanged files with checksum = false
+}
+
+// FirewallRecord is a single firewall rule with its templates rendered.
+type FirewallRecord struct {
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	From      string `json:"from,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// DeployLock is stored on each server while an environment is being deployed,
+// so two deploys of the same environment can't run at the same time.
+type DeployLock struct {
+	ID          string    `json:"id"` // unique for each deploy
+	Environment string    `json:"environment"`
+	User        string    `json:"user,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Holder describes who holds the lock, e.g. "alice on laptop since 2017-06-01 12:00:00 UTC"
+func (l *DeployLock) Holder() string {
+	return fmt.Sprintf("%v on %v since %v", l.User, l.Host, l.Time.UTC().Format("2006-01-02 15:04:05 MST"))
+}
+
+// GitInfo is the state of the git repository of the project when it was deployed
+type GitInfo struct {
+	Commit string `json:"commit"`
+	Branch string `json:"branch,omitempty"` // empty for a detached HEAD
+	Dirty  bool   `json:"dirty,omitempty"`  // true if there were uncommitted changes
+}
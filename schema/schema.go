@@ -4,12 +4,20 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/oliverkofoed/dogo/commandtree"
 )
 
 const AgentPath = "/usr/local/bin/dogoagent"
 
+// DeploymentsPath is where the agent stores deployment records on servers
+const DeploymentsPath = "/var/lib/dogo/deployments"
+
+// LocksPath is where deploy locks are stored on servers. They're created with the
+// shell (not the agent), so a server can be locked before the agent is installed.
+const LocksPath = "/var/lib/dogo/locks"
+
 type Config struct {
 	Environments   map[string]*Environment
 	Packages       map[string]*Package
@@ -23,14 +31,57 @@ type Environment struct {
 	Resources          map[string]*Resource
 	ResourcesByPackage map[string][]*Resource
 	DeploymentHooks    []*DeploymentHook
+	Rolling            *Rolling  // rolling deploy settings for servers without a package level setting
+	Parallel           *Parallel // concurrency limits for deploys
+	Notify             []*Notify // webhooks told about deploys
+	RequireCleanGit    bool      // refuse to deploy with uncommitted changes
+	AllowedBranches    []string  // if set, only deploy from these git branches
 }
 
 type DeploymentHook struct {
 	RunBeforeDeployment bool
 	RunAfterDeployment  bool
+	RunBeforeServer     bool // run on each server right before its remote commands
+	RunAfterServer      bool // run on each server right after its remote commands
 	CommandPackage      string
 	CommandName         string
 	Command             *Command
+	HookSettings
+}
+
+// values for HookSettings.OnFailure
+const (
+	HookOnFailureAbort    = "abort"
+	HookOnFailureContinue = "continue"
+)
+
+// HookSettings are the settings of a deployment hook. They're given along with
+// the arguments for the hook's command.
+type HookSettings struct {
+	Order       int      `description:"Hooks run in ascending order of this number. before_deployment and after_deployment hooks with the same order run at the same time"`
+	OnFailure   string   `name:"on_failure" default:"abort" description:"What to do if the hook fails: 'abort' stops the deploy, 'continue' carries on"`
+	WhenChanged []string `name:"when_changed" description:"Only run the hook if one of these modules (e.g. 'docker') changes something. For before_server and after_server hooks the change must be on that server"`
+}
+
+// Validate checks the hook settings
+func (h *HookSettings) Validate() error {
+	if h.OnFailure != HookOnFailureAbort && h.OnFailure != HookOnFailureContinue {
+		return fmt.Errorf("on_failure must be '%v' or '%v'. Got: %v", HookOnFailureAbort, HookOnFailureContinue, h.OnFailure)
+	}
+	return nil
+}
+
+// Applies returns true if the hook should run, given the modules that changed something
+func (h *HookSettings) Applies(changedModules map[string]bool) bool {
+	if len(h.WhenChanged) == 0 {
+		return true
+	}
+	for _, m := range h.WhenChanged {
+		if changedModules[m] {
+			return true
+		}
+	}
+	return false
 }
 
 type Package struct {
@@ -38,6 +89,7 @@ type Package struct {
 	Tunnels  map[string]*Tunnel
 	Commands map[string]*Command
 	Modules  []*PackageModule
+	Rolling  *Rolling
 }
 
 type PackageModule struct {
@@ -54,6 +106,97 @@ type Command struct {
 	Target   Template   `description:"Which server(s) to run this command against. Valid values are either '' (the first server), '*' (all servers) or 'servername' (just that server)."`
 }
 
+// Rolling configures a rolling deploy, where the remote commands are run on a
+// batch of servers at a time, waiting for them to be healthy before moving on.
+type Rolling struct {
+	Batch       int    `default:"1" description:"The number of servers to deploy to at the same time"`
+	WaitHealthy string `name:"wait_healthy" description:"How long to wait for the containers in a batch to be running (and healthy, if they have a health check) before deploying the next batch, e.g. '30s'. If empty the next batch is deployed right away."`
+}
+
+// Validate checks the rolling settings
+func (r *Rolling) Validate() error {
+	if r.Batch < 1 {
+		return fmt.Errorf("batch must be at least 1. Got: %v", r.Batch)
+	}
+	if r.WaitHealthy != "" {
+		if _, err := time.ParseDuration(r.WaitHealthy); err != nil {
+			return fmt.Errorf("wait_healthy must be a duration such as '30s'. Got: %v", r.WaitHealthy)
+		}
+	}
+	return nil
+}
+
+// Wait returns how long to wait for the containers in a batch to be healthy
+func (r *Rolling) Wait() time.Duration {
+	d, _ := time.ParseDuration(r.WaitHealthy)
+	return d
+}
+
+// Parallel limits how much of a deploy runs at the same time. A limit of 0 means no limit
+// (other than the number of servers deployed at the same time, set with --parallel).
+type Parallel struct {
+	Provision int            `description:"The max number of servers provisioned (and connected to) at the same time"`
+	Local     int            `description:"The max number of local commands, such as pushing docker images, run at the same time"`
+	Remote    int            `description:"The max number of servers running their remote commands at the same time"`
+	Commands  int            `default:"5" description:"The max number of remote commands run at the same time on each server"`
+	Managers  map[string]int `name:"-"` // resource manager name => max servers provisioned at the same time
+}
+
+// Validate checks the parallel settings
+func (p *Parallel) Validate() error {
+	for name, value := range map[string]int{"provision": p.Provision, "local": p.Local, "remote": p.Remote} {
+		if value < 0 {
+			return fmt.Errorf("%v can't be negative. Got: %v", name, value)
+		}
+	}
+	if p.Commands < 1 {
+		return fmt.Errorf("commands must be at least 1. Got: %v", p.Commands)
+	}
+	return nil
+}
+
+// formats and events of notify blocks
+const (
+	NotifyFormatGeneric = "generic"
+	NotifyFormatSlack   = "slack"
+	NotifyEventStart    = "start"
+	NotifyEventFinish   = "finish"
+	NotifyEventFailure  = "failure"
+)
+
+// Notify posts the start, finish and failure of deploys to a webhook
+type Notify struct {
+	URL    Template `required:"true" description:"The url to POST the events to. Keep secret urls (such as slack webhooks) in the vault"`
+	Format string   `default:"generic" description:"The format of the events: 'generic' posts the event as JSON, 'slack' posts a slack compatible message"`
+	Events []string `description:"The events to post: start, finish and/or failure. All events are posted if empty"`
+}
+
+// Validate checks the notify settings
+func (n *Notify) Validate() error {
+	if n.Format != NotifyFormatGeneric && n.Format != NotifyFormatSlack {
+		return fmt.Errorf("format must be '%v' or '%v'. Got: %v", NotifyFormatGeneric, NotifyFormatSlack, n.Format)
+	}
+	for _, event := range n.Events {
+		if event != NotifyEventStart && event != NotifyEventFinish && event != NotifyEventFailure {
+			return fmt.Errorf("events must be one of '%v', '%v' or '%v'. Got: %v", NotifyEventStart, NotifyEventFinish, NotifyEventFailure, event)
+		}
+	}
+	return nil
+}
+
+// Wants returns true if the event should be posted
+func (n *Notify) Wants(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
 // Tunnel represents information about a socket tunnel (typically SSH tunnel)
 type Tunnel struct {
 	Port int      `required:"true" description:"the local port to to use for the tunnel"`
@@ -122,6 +265,9 @@ type CalculateCommandsArgs struct {
 	RemoteConnection ServerConnection
 	Environment      *Environment
 	Config           *Config
+	Record           *DeploymentRecord // modules add what they deploy to this
+	Rollback         *DeploymentRecord // if set, deploy this instead of Modules
+	Git              *GitInfo          // the git state of the project being deployed. nil if it isn't in a git repository
 	Logf             func(format string, args ...interface{})
 	Errf             func(format string, args ...interface{})
 	Err              func(err error)
@@ -143,6 +289,7 @@ type ResourceManager struct {
 	GroupPrototype    interface{}
 	ResourcePrototype interface{}
 	Provision         func(group interface{}, resource interface{}, l Logger) error
+	Lookup            func(group interface{}, resource interface{}, l Logger) (bool, error) // finds an already provisioned resource without changing anything. Optional.
 	FindUnused        func(shouldExist map[interface{}][]string, decommisionRoot *commandtree.Command, l Logger) ([]string, error)
 }
 
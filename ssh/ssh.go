@@ -217,7 +217,7 @@ func (s *SSHConnection) WriteFile(path string, mode os.FileMode, contentLength i
 
 	// write data in seperate go routine
 	go func() {
-		fmt.Fprintln(writer, fmt.Sprintf("C%04d", int(mode)), contentLength, filepath.Base(path))
+		fmt.Fprintln(writer, fmt.Sprintf("C%04o", mode.Perm()), contentLength, filepath.Base(path))
 
 		arr := make([]byte, 32*1024)
 		written := int64(0)
@@ -18,6 +18,11 @@ import (
 	"github.com/oliverkofoed/jet"
 )
 
+// templateVersion is the global 'version' template variable. It has always been the
+// version of the etcd library (not of dogo), and changing it would change every template
+// that uses it, and with that the containers that would be redeployed.
+var templateVersion = version.Version
+
 type templateSource struct {
 	templateSet *jet.Set
 }
@@ -46,7 +51,7 @@ func (t *templateSource) AddGlobal(key string, value interface{}) {
 
 func newTemplateSource() *templateSource {
 	templateSet := jet.NewSet(func(w io.Writer, b []byte) { w.Write(b) })
-	templateSet.AddGlobal("version", version.Version)
+	templateSet.AddGlobal("version", templateVersion)
 	templateSet.AddGlobalFunc("vaultstring", func(a jet.Arguments) reflect.Value {
 		a.RequireNumOfArguments("vaultstring", 2, 2)
 		file := a.Get(0).String()
@@ -97,7 +97,7 @@ func TestModule(t *testing.T, checkAndUpdateAgent bool, dogoRootDir string, mana
 			if err != nil {
 				errf(err.Error())
 			}
-			err = connection.WriteFile(schema.AgentPath, 755, int64(len(agentBytes)), bytes.NewReader(agentBytes), true, func(p float64) {})
+			err = connection.WriteFile(schema.AgentPath, 0755, int64(len(agentBytes)), bytes.NewReader(agentBytes), true, func(p float64) {})
 		}
 	} else {
 		logf("[WARN] NOT CHECKING IF AGENT IS VALID OR UP-TO-DATE. PROCEED AT YOUR OWN RISK.")
@@ -150,6 +150,7 @@ func TestModule(t *testing.T, checkAndUpdateAgent bool, dogoRootDir string, mana
 		LocalCommands:    commandtree.NewRootCommand("Local Commands"),
 		RemoteCommands:   remote,
 		RemoteConnection: connection,
+		Record:           &schema.DeploymentRecord{},
 	}
 
 	// calculate changes for each module
@@ -3,8 +3,13 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"strings"
 
+	"github.com/oliverkofoed/dogo/commandtree"
 	"github.com/oliverkofoed/dogo/neaterror"
+	"github.com/oliverkofoed/dogo/registry/modules/docker"
+	"github.com/oliverkofoed/dogo/schema"
 	"github.com/oliverkofoed/dogo/term"
 )
 
@@ -39,3 +44,32 @@ func runChar(input []rune, length int) string {
 	}
 	return b.String()
 }
+
+// shellQuote quotes the string for use as a single argument in a POSIX shell command.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// dockerContainerNames returns the names of the containers declared by the
+// docker modules on the resource. Unnamed (cron) containers are skipped.
+func dockerContainerNames(res *schema.Resource) []string {
+	names := make([]string, 0)
+	if modules, ok := res.Modules[docker.Manager.Name].([]*docker.Docker); ok {
+		for _, m := range modules {
+			name, err := m.Name.Render(nil)
+			if err != nil || name == "" {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// runUI shows the progress of the command tree in the format selected with --output
+func runUI(root commandtree.CommandNode) error {
+	if flagOutput == outputJSON {
+		return commandtree.JSONUI(root, os.Stdout)
+	}
+	return commandtree.ConsoleUI(root)
+}